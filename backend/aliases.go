@@ -0,0 +1,489 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Что нормализует псевдоним
+const (
+	aliasFieldBrand = "brand"
+	aliasFieldModel = "model"
+)
+
+// Псевдоним марки или модели: исходное написание → каноническое.
+// Для модели Make ограничивает псевдоним одной маркой; пустое — любая.
+// Prefix — совпадение по началу строки ("SHACMAN SX4258" → Shacman).
+type VehicleAlias struct {
+	ID        int       `json:"id"`
+	Field     string    `json:"field"`
+	Raw       string    `json:"raw"`
+	Canonical string    `json:"canonical"`
+	Make      string    `json:"make,omitempty"`
+	Prefix    bool      `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Словарь, загруженный для одного прогона нормализации
+type aliasDictionary struct {
+	exact  map[string]VehicleAlias
+	prefix []VehicleAlias
+}
+
+func ensureAliasTable() error {
+	var exists bool
+	if err := db.QueryRow(`SELECT to_regclass('vehicle_aliases') IS NOT NULL`).Scan(&exists); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS vehicle_aliases (
+       id SERIAL PRIMARY KEY,
+       field TEXT NOT NULL,
+       raw TEXT NOT NULL,
+       canonical TEXT NOT NULL,
+       make TEXT,
+       prefix BOOLEAN DEFAULT false,
+       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+       updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS vehicle_aliases_key_idx ON vehicle_aliases (field, raw, COALESCE(make, ''));
+    `)
+	if err != nil || exists {
+		return err
+	}
+	return seedBrandAliases()
+}
+
+// Начальный словарь марок из таблицы расшифровки VIN; только при создании таблицы,
+// чтобы удалённые вручную псевдонимы не возвращались
+func seedBrandAliases() error {
+	stmt, err := db.Prepare(`
+       INSERT INTO vehicle_aliases (field, raw, canonical, prefix) VALUES ($1, $2, $3, true)
+       ON CONFLICT (field, raw, COALESCE(make, '')) DO NOTHING
+    `)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, m := range vinDecoder.Manufacturers {
+		if m.Brand == "" {
+			continue
+		}
+		for _, name := range append([]string{m.Brand}, m.Aliases...) {
+			if _, err := stmt.Exec(aliasFieldBrand, aliasKey(name), m.Brand); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Ключ сравнения: нижний регистр, ё→е, одиночные пробелы
+func aliasKey(value string) string {
+	value = strings.ReplaceAll(strings.ToLower(value), "ё", "е")
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func loadAliasDictionary(q dbExecutor, field string) (*aliasDictionary, error) {
+	rows, err := q.Query(`
+       SELECT id, field, raw, canonical, COALESCE(make, ''), COALESCE(prefix, false), created_at, updated_at
+       FROM vehicle_aliases WHERE field = $1
+    `, field)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dict := &aliasDictionary{exact: make(map[string]VehicleAlias)}
+	for rows.Next() {
+		a, err := scanAlias(rows)
+		if err != nil {
+			return nil, err
+		}
+		dict.exact[aliasDictKey(a.Make, a.Raw)] = a
+		if a.Prefix {
+			dict.prefix = append(dict.prefix, a)
+		}
+	}
+	// Длинный префикс точнее короткого: "man truck" раньше "man"
+	sort.Slice(dict.prefix, func(i, j int) bool { return len(dict.prefix[i].Raw) > len(dict.prefix[j].Raw) })
+	return dict, rows.Err()
+}
+
+func aliasDictKey(brand, raw string) string {
+	return aliasKey(brand) + "\x00" + raw
+}
+
+// Каноническое значение для raw; brand сужает поиск моделей. Пустая строка — не найдено.
+func (d *aliasDictionary) lookup(brand, raw string) string {
+	key := aliasKey(raw)
+	if key == "" {
+		return ""
+	}
+	for _, m := range []string{brand, ""} {
+		if a, ok := d.exact[aliasDictKey(m, key)]; ok {
+			return a.Canonical
+		}
+	}
+	for _, a := range d.prefix {
+		if a.Make != "" && !strings.EqualFold(a.Make, brand) {
+			continue
+		}
+		if key == a.Raw || strings.HasPrefix(key, a.Raw+" ") {
+			return a.Canonical
+		}
+	}
+	return ""
+}
+
+// Словари марок и моделей одного прогона
+type makeModelNormalizer struct {
+	brands *aliasDictionary
+	models *aliasDictionary
+}
+
+func loadMakeModelNormalizer(q dbExecutor) (*makeModelNormalizer, error) {
+	brands, err := loadAliasDictionary(q, aliasFieldBrand)
+	if err != nil {
+		return nil, err
+	}
+	models, err := loadAliasDictionary(q, aliasFieldModel)
+	if err != nil {
+		return nil, err
+	}
+	return &makeModelNormalizer{brands: brands, models: models}, nil
+}
+
+// Канонические марка и модель записи по полям make_field и model_field источника
+func (n *makeModelNormalizer) apply(def *SourceDefinition, rec *Record) {
+	rec.CanonicalMake, rec.CanonicalModel = "", ""
+	if def.MakeField != "" {
+		rec.CanonicalMake = n.brands.lookup("", rec.Values[def.MakeField])
+	}
+	if def.ModelField != "" {
+		rec.CanonicalModel = n.models.lookup(rec.CanonicalMake, rec.Values[def.ModelField])
+	}
+}
+
+// Нормализация марок и моделей всех записей источника по текущему словарю;
+// возвращает число записей, у которых результат изменился. Источник без make_field
+// и model_field тоже просматривается: прежние значения у него сбрасываются.
+func renormalizeSource(q dbExecutor, n *makeModelNormalizer, def *SourceDefinition) (int, error) {
+	rows, err := q.Query(fmt.Sprintf("SELECT %s FROM %s", selectColumns(def), def.Table))
+	if err != nil {
+		return 0, err
+	}
+	var stale []Record
+	for rows.Next() {
+		rec, err := scanRecord(def, rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		before := rec
+		n.apply(def, &rec)
+		if rec.CanonicalMake != before.CanonicalMake || rec.CanonicalModel != before.CanonicalModel {
+			stale = append(stale, rec)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	stmt, err := q.Prepare(fmt.Sprintf("UPDATE %s SET canonical_make = $1, canonical_model = $2 WHERE id = $3", def.Table))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, rec := range stale {
+		if _, err := stmt.Exec(nullIfEmpty(rec.CanonicalMake), nullIfEmpty(rec.CanonicalModel), rec.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), nil
+}
+
+// Нарушение уникального индекса: такое написание уже есть в словаре
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func scanAlias(row rowScanner) (VehicleAlias, error) {
+	var a VehicleAlias
+	err := row.Scan(&a.ID, &a.Field, &a.Raw, &a.Canonical, &a.Make, &a.Prefix, &a.CreatedAt, &a.UpdatedAt)
+	return a, err
+}
+
+// Тело запроса создания и изменения псевдонима
+type aliasPayload struct {
+	Field     string `json:"field"`
+	Raw       string `json:"raw"`
+	Canonical string `json:"canonical"`
+	Make      string `json:"make"`
+	Prefix    bool   `json:"prefix"`
+}
+
+func decodeAliasPayload(r *http.Request) (aliasPayload, error) {
+	var p aliasPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return p, fmt.Errorf("invalid request body")
+	}
+	p.Raw = aliasKey(p.Raw)
+	p.Canonical = strings.TrimSpace(p.Canonical)
+	p.Make = strings.TrimSpace(p.Make)
+	switch {
+	case p.Field != aliasFieldBrand && p.Field != aliasFieldModel:
+		return p, fmt.Errorf("field must be %q or %q", aliasFieldBrand, aliasFieldModel)
+	case p.Raw == "":
+		return p, fmt.Errorf("raw is required")
+	case p.Canonical == "":
+		return p, fmt.Errorf("canonical is required")
+	case p.Field == aliasFieldBrand && p.Make != "":
+		return p, fmt.Errorf("make applies only to model aliases")
+	}
+	return p, nil
+}
+
+// Маршруты словаря марок и моделей; создание, изменение и удаление — с токеном администратора
+func RegisterAliasRoutes(r *mux.Router) {
+	r.HandleFunc("/api/aliases", listAliasesHandler).Methods("GET")
+	r.HandleFunc("/api/aliases", createAliasHandler).Methods("POST")
+	r.HandleFunc("/api/aliases/unmapped", unmappedAliasesHandler).Methods("GET")
+	r.HandleFunc("/api/aliases/renormalize", renormalizeHandler).Methods("POST")
+	r.HandleFunc("/api/aliases/{id:[0-9]+}", updateAliasHandler).Methods("PUT")
+	r.HandleFunc("/api/aliases/{id:[0-9]+}", deleteAliasHandler).Methods("DELETE")
+}
+
+// Список псевдонимов; фильтры field и q (подстрока исходного или канонического написания)
+func listAliasesHandler(w http.ResponseWriter, r *http.Request) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	if v := r.URL.Query().Get("field"); v != "" {
+		args = append(args, v)
+		conditions = append(conditions, fmt.Sprintf("field = $%d", len(args)))
+	}
+	if v := r.URL.Query().Get("q"); v != "" {
+		args = append(args, "%"+v+"%")
+		conditions = append(conditions, fmt.Sprintf("(raw ILIKE $%d OR canonical ILIKE $%d)", len(args), len(args)))
+	}
+
+	rows, err := db.Query(`
+       SELECT id, field, raw, canonical, COALESCE(make, ''), COALESCE(prefix, false), created_at, updated_at
+       FROM vehicle_aliases WHERE `+strings.Join(conditions, " AND ")+`
+       ORDER BY field, canonical, raw
+    `, args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch aliases", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := make([]VehicleAlias, 0)
+	for rows.Next() {
+		a, err := scanAlias(rows)
+		if err != nil {
+			http.Error(w, "Failed to fetch aliases", http.StatusInternalServerError)
+			return
+		}
+		items = append(items, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+func createAliasHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	p, err := decodeAliasPayload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a, err := scanAlias(db.QueryRow(`
+       INSERT INTO vehicle_aliases (field, raw, canonical, make, prefix)
+       VALUES ($1, $2, $3, $4, $5)
+       ON CONFLICT (field, raw, COALESCE(make, '')) DO NOTHING
+       RETURNING id, field, raw, canonical, COALESCE(make, ''), prefix, created_at, updated_at
+    `, p.Field, p.Raw, p.Canonical, nullIfEmpty(p.Make), p.Prefix))
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Alias %q for %s already exists", p.Raw, p.Field), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create alias: %v", err)
+		http.Error(w, "Failed to create alias", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+func updateAliasHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	p, err := decodeAliasPayload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a, err := scanAlias(db.QueryRow(`
+       UPDATE vehicle_aliases
+       SET field = $1, raw = $2, canonical = $3, make = $4, prefix = $5, updated_at = CURRENT_TIMESTAMP
+       WHERE id = $6
+       RETURNING id, field, raw, canonical, COALESCE(make, ''), prefix, created_at, updated_at
+    `, p.Field, p.Raw, p.Canonical, nullIfEmpty(p.Make), p.Prefix, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Alias not found", http.StatusNotFound)
+		return
+	}
+	if isUniqueViolation(err) {
+		http.Error(w, fmt.Sprintf("Alias %q for %s already exists", p.Raw, p.Field), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update alias %d: %v", id, err)
+		http.Error(w, "Failed to update alias", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+func deleteAliasHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	res, err := db.Exec(`DELETE FROM vehicle_aliases WHERE id = $1`, id)
+	if err != nil {
+		http.Error(w, "Failed to delete alias", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Alias not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Исходное написание без канонического значения и сколько раз оно встречается
+type UnmappedValue struct {
+	Source string `json:"source"`
+	Field  string `json:"field"`
+	Make   string `json:"make,omitempty"`
+	Raw    string `json:"raw"`
+	Count  int    `json:"count"`
+}
+
+// Марки и модели, для которых в словаре нет псевдонима; field=brand|model, source
+func unmappedAliasesHandler(w http.ResponseWriter, r *http.Request) {
+	field := r.URL.Query().Get("field")
+	if field != "" && field != aliasFieldBrand && field != aliasFieldModel {
+		http.Error(w, fmt.Sprintf("field must be %q or %q", aliasFieldBrand, aliasFieldModel), http.StatusBadRequest)
+		return
+	}
+	source := r.URL.Query().Get("source")
+
+	items := make([]UnmappedValue, 0)
+	for _, def := range sources {
+		if source != "" && def.Name != source {
+			continue
+		}
+		var queries []struct{ field, query string }
+		if def.MakeField != "" && field != aliasFieldModel {
+			queries = append(queries, struct{ field, query string }{aliasFieldBrand, fmt.Sprintf(`
+               SELECT '', %s, COUNT(*) FROM %s
+               WHERE canonical_make IS NULL AND COALESCE(%s, '') <> ''
+               GROUP BY %s`, def.MakeField, def.Table, def.MakeField, def.MakeField)})
+		}
+		if def.ModelField != "" && field != aliasFieldBrand {
+			queries = append(queries, struct{ field, query string }{aliasFieldModel, fmt.Sprintf(`
+               SELECT COALESCE(canonical_make, ''), %s, COUNT(*) FROM %s
+               WHERE canonical_model IS NULL AND COALESCE(%s, '') <> ''
+               GROUP BY canonical_make, %s`, def.ModelField, def.Table, def.ModelField, def.ModelField)})
+		}
+
+		for _, uq := range queries {
+			rows, err := db.Query(uq.query)
+			if err != nil {
+				log.Printf("Failed to fetch unmapped %s for %s: %v", uq.field, def.Name, err)
+				http.Error(w, "Failed to fetch unmapped values", http.StatusInternalServerError)
+				return
+			}
+			for rows.Next() {
+				u := UnmappedValue{Source: def.Name, Field: uq.field}
+				if err := rows.Scan(&u.Make, &u.Raw, &u.Count); err != nil {
+					rows.Close()
+					http.Error(w, "Failed to fetch unmapped values", http.StatusInternalServerError)
+					return
+				}
+				items = append(items, u)
+			}
+			rows.Close()
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Count > items[j].Count })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+// Повторная нормализация существующих записей всех источников по текущему словарю
+func renormalizeHandler(w http.ResponseWriter, r *http.Request) {
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	n, err := loadMakeModelNormalizer(tx)
+	if err != nil {
+		http.Error(w, "Failed to load aliases", http.StatusInternalServerError)
+		return
+	}
+
+	updated := make(map[string]int)
+	for _, def := range sources {
+		count, err := renormalizeSource(tx, n, def)
+		if err != nil {
+			log.Printf("Failed to renormalize %s: %v", def.Name, err)
+			http.Error(w, "Failed to renormalize records", http.StatusInternalServerError)
+			return
+		}
+		updated[def.Name] = count
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to renormalize records", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"updated": updated})
+}
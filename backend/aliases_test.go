@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestMakeModelNormalizerUsesMakeField(t *testing.T) {
+	shacman := VehicleAlias{Field: aliasFieldBrand, Raw: "shacman", Canonical: "Shacman", Prefix: true}
+	n := &makeModelNormalizer{
+		brands: &aliasDictionary{
+			exact:  map[string]VehicleAlias{aliasDictKey("", shacman.Raw): shacman},
+			prefix: []VehicleAlias{shacman},
+		},
+		models: &aliasDictionary{exact: map[string]VehicleAlias{}},
+	}
+
+	rec := newRecord(v3Source)
+	rec.Values["brand"] = "SHACMAN SX4258"
+	n.apply(v3Source, &rec)
+	if rec.CanonicalMake != "Shacman" {
+		t.Errorf("v3 canonical make = %q, want %q", rec.CanonicalMake, "Shacman")
+	}
+
+	// У v1 марка — свободный текст предмета лизинга, словарь к нему не применяется
+	rec = newRecord(v1Source)
+	rec.Values["subject"] = "SHACMAN SX4258 седельный тягач"
+	rec.CanonicalMake = "Shacman"
+	n.apply(v1Source, &rec)
+	if rec.CanonicalMake != "" {
+		t.Errorf("v1 canonical make = %q, want empty", rec.CanonicalMake)
+	}
+}
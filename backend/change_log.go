@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Запись журнала изменений: одно поле одной машины в одной загрузке
+type ChangeLogEntry struct {
+	ID        int       `json:"id"`
+	Source    string    `json:"source"`
+	VIN       string    `json:"vin"`
+	Field     string    `json:"field"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+	UploadID  *int      `json:"upload_id"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+func ensureChangeLogTable() error {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS change_log (
+       id SERIAL PRIMARY KEY,
+       source TEXT NOT NULL,
+       vin TEXT NOT NULL,
+       field TEXT NOT NULL,
+       old_value TEXT,
+       new_value TEXT,
+       upload_id INTEGER REFERENCES uploads(id) ON DELETE SET NULL,
+       changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS change_log_vin_idx ON change_log (source, vin, changed_at);
+    CREATE INDEX IF NOT EXISTS change_log_field_idx ON change_log (source, field, changed_at);
+    CREATE INDEX IF NOT EXISTS change_log_upload_idx ON change_log (upload_id);
+    `)
+	return err
+}
+
+// Запись найденных при сравнении отличий в журнал
+func logChanges(run *importRun, diff RecordDiff) error {
+	var uploadID interface{}
+	if run.uploadID != 0 {
+		uploadID = run.uploadID
+	}
+	for _, c := range diff.Changes {
+		_, err := run.tx.Exec(`
+           INSERT INTO change_log (source, vin, field, old_value, new_value, upload_id)
+           VALUES ($1, $2, $3, $4, $5, $6)
+        `, run.def.Name, diff.VIN, c.Field, c.Old, c.New, uploadID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Журнал изменений источника с фильтрами vin, field, upload_id, from, to
+func changeLogHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, limit, err := parsePagination(r, 100, 1000)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		q := r.URL.Query()
+		conditions := []string{"source = $1"}
+		args := []interface{}{def.Name}
+		add := func(condition string, value interface{}) {
+			args = append(args, value)
+			conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+		}
+
+		if v := q.Get("vin"); v != "" {
+			add("vin = $%d", lookupVIN(v))
+		}
+		if v := q.Get("field"); v != "" {
+			add("field = $%d", v)
+		}
+		if v := q.Get("upload_id"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid upload_id %q", v), http.StatusBadRequest)
+				return
+			}
+			add("upload_id = $%d", id)
+		}
+		if v := q.Get("from"); v != "" {
+			from, err := parseDateParam(v, false)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+				return
+			}
+			add("changed_at >= $%d", from)
+		}
+		if v := q.Get("to"); v != "" {
+			to, err := parseDateParam(v, true)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+				return
+			}
+			add("changed_at < $%d", to)
+		}
+
+		where := strings.Join(conditions, " AND ")
+
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM change_log WHERE "+where, args...).Scan(&total); err != nil {
+			http.Error(w, "Failed to fetch change log", http.StatusInternalServerError)
+			return
+		}
+
+		query := fmt.Sprintf(`
+           SELECT id, source, vin, field, COALESCE(old_value, ''), COALESCE(new_value, ''), upload_id, changed_at
+           FROM change_log WHERE %s
+           ORDER BY changed_at DESC, id DESC
+           LIMIT $%d OFFSET $%d
+        `, where, len(args)+1, len(args)+2)
+		rows, err := db.Query(query, append(args, limit, (page-1)*limit)...)
+		if err != nil {
+			http.Error(w, "Failed to fetch change log", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		items := make([]ChangeLogEntry, 0)
+		for rows.Next() {
+			var e ChangeLogEntry
+			var uploadID sql.NullInt64
+			if err := rows.Scan(&e.ID, &e.Source, &e.VIN, &e.Field, &e.OldValue, &e.NewValue, &uploadID, &e.ChangedAt); err != nil {
+				http.Error(w, "Failed to fetch change log", http.StatusInternalServerError)
+				return
+			}
+			if uploadID.Valid {
+				id := int(uploadID.Int64)
+				e.UploadID = &id
+			}
+			items = append(items, e)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"items": items,
+			"total": total,
+			"page":  page,
+			"limit": limit,
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Описание столбца входного файла: поле записи, допустимые заголовки
+// и обязательность столбца в файле
+type columnSpec struct {
+	Field    string
+	Aliases  []string
+	Required bool
+}
+
+// Соответствие поля записи индексу столбца (0-based)
+type columnMap map[string]int
+
+// Ошибка: в строке заголовков не найдены обязательные столбцы
+type missingColumnsError struct {
+	Missing []columnSpec
+}
+
+func (e *missingColumnsError) Error() string {
+	parts := make([]string, 0, len(e.Missing))
+	for _, spec := range e.Missing {
+		parts = append(parts, fmt.Sprintf("%s (expected one of: %s)", spec.Field, strings.Join(quoteAll(spec.Aliases), ", ")))
+	}
+	return "missing required columns: " + strings.Join(parts, "; ")
+}
+
+// Поиск столбцов по тексту заголовков в первой строке листа; ненайденные
+// необязательные столбцы в результат не попадают
+func resolveColumns(header []string, specs []columnSpec) (columnMap, error) {
+	positions := make(map[string]int, len(header))
+	for i, title := range header {
+		key := normalizeHeader(title)
+		if key == "" {
+			continue
+		}
+		if _, ok := positions[key]; !ok {
+			positions[key] = i
+		}
+	}
+
+	cols := make(columnMap, len(specs))
+	var missing []columnSpec
+	for _, spec := range specs {
+		found := false
+		for _, alias := range spec.Aliases {
+			if idx, ok := positions[normalizeHeader(alias)]; ok {
+				cols[spec.Field] = idx
+				found = true
+				break
+			}
+		}
+		if !found && spec.Required {
+			missing = append(missing, spec)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, &missingColumnsError{Missing: missing}
+	}
+	return cols, nil
+}
+
+// Значение поля в строке; GetRows обрезает пустые ячейки в конце строки
+func (m columnMap) value(row []string, field string) string {
+	idx, ok := m[field]
+	if !ok || idx >= len(row) {
+		return ""
+	}
+	return row[idx]
+}
+
+// Значение поля для записи, которая уже есть в базе: если столбца нет в файле,
+// остаётся сохранённое значение, а не стирается
+func (m columnMap) keep(field, value, stored string) string {
+	if _, ok := m[field]; !ok {
+		return stored
+	}
+	return value
+}
+
+// Приведение заголовка к виду для сравнения: регистр, ё, лишние пробелы
+func normalizeHeader(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, "ё", "е")
+	s = strings.ReplaceAll(s, "\u00a0", " ")
+	s = strings.TrimRight(s, ":")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return quoted
+}
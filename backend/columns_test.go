@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestResolveColumns(t *testing.T) {
+	specs := []columnSpec{
+		{Field: "vin", Aliases: []string{"VIN", "VIN номер"}, Required: true},
+		{Field: "price", Aliases: []string{"Цена", "Текущая цена"}, Required: true},
+		{Field: "city", Aliases: []string{"Местонахождение"}},
+	}
+	tests := []struct {
+		name    string
+		header  []string
+		want    columnMap
+		missing []string
+	}{
+		{
+			name:   "any order and aliases",
+			header: []string{"Текущая цена", "", "vin номер", "Местонахождение"},
+			want:   columnMap{"vin": 2, "price": 0, "city": 3},
+		},
+		{
+			name:   "case, colon and spaces",
+			header: []string{"  VIN: ", "ЦЕНА", "Местонахождение "},
+			want:   columnMap{"vin": 0, "price": 1, "city": 2},
+		},
+		{
+			name:   "first duplicate wins",
+			header: []string{"VIN", "Цена", "VIN", "Местонахождение"},
+			want:   columnMap{"vin": 0, "price": 1, "city": 3},
+		},
+		{
+			name:   "optional column absent",
+			header: []string{"VIN", "Цена"},
+			want:   columnMap{"vin": 0, "price": 1},
+		},
+		{
+			name:    "missing required columns",
+			header:  []string{"Местонахождение"},
+			missing: []string{"vin", "price"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveColumns(tt.header, specs)
+			if tt.missing != nil {
+				var me *missingColumnsError
+				if !errors.As(err, &me) {
+					t.Fatalf("expected missingColumnsError, got %v", err)
+				}
+				fields := make([]string, 0, len(me.Missing))
+				for _, spec := range me.Missing {
+					fields = append(fields, spec.Field)
+				}
+				if !reflect.DeepEqual(fields, tt.missing) {
+					t.Errorf("missing = %v, want %v", fields, tt.missing)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveColumns = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Файл без необязательного столбца не стирает сохранённые значения
+func TestColumnMapKeepsStoredValues(t *testing.T) {
+	specs := []columnSpec{
+		{Field: "vin", Aliases: []string{"VIN"}, Required: true},
+		{Field: "price", Aliases: []string{"Цена"}, Required: true},
+		{Field: "city", Aliases: []string{"Местонахождение"}},
+	}
+	cols, err := resolveColumns([]string{"VIN", "Цена"}, specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := []string{"WDB9634031L123456", "1500000"}
+
+	if got := cols.keep("city", cols.value(row, "city"), "Москва"); got != "Москва" {
+		t.Errorf("absent city = %q, want stored %q", got, "Москва")
+	}
+	if got := cols.keep("price", cols.value(row, "price"), "1600000"); got != "1500000" {
+		t.Errorf("price = %q, want value from file %q", got, "1500000")
+	}
+	if got := cols.keep("price", "", "1600000"); got != "" {
+		t.Errorf("empty price cell = %q, want empty: the column is present", got)
+	}
+}
+
+func TestColumnSpecsRequired(t *testing.T) {
+	required := make(map[string]bool)
+	for _, spec := range v3Source.columnSpecs() {
+		if spec.Required {
+			required[spec.Field] = true
+		}
+	}
+	want := map[string]bool{"vin": true, "actual_price": true, "status": true}
+	if !reflect.DeepEqual(required, want) {
+		t.Errorf("required columns = %v, want %v", required, want)
+	}
+}
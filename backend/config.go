@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var identifierPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+var routePrefixPattern = regexp.MustCompile(`^/api(/[a-z0-9_-]+)*$`)
+
+// Столбцы, которые движок добавляет в каждую таблицу источника сам
+var reservedColumns = map[string]bool{
+	"id":                 true,
+	"old_price":          true,
+	"photos":             true,
+	"is_new":             true,
+	"changed_columns":    true,
+	"created_at":         true,
+	"updated_at":         true,
+	"withdrawn_at":       true,
+	"lifecycle_status":   true,
+	"status_changed_at":  true,
+	"sold_at":            true,
+	"relisted_at":        true,
+	"vin_manufacturer":   true,
+	"vin_brand":          true,
+	"vin_country":        true,
+	"vin_model_year":     true,
+	"vin_brand_mismatch": true,
+	"vin_year_mismatch":  true,
+	"canonical_make":     true,
+	"canonical_model":    true,
+	"geo_city":           true,
+	"geo_region":         true,
+	"geo_lat":            true,
+	"geo_lon":            true,
+}
+
+// Общие таблицы движка: источник не может использовать их как свою
+var reservedTables = map[string]bool{
+	"uploads":          true,
+	"upload_records":   true,
+	"upload_previews":  true,
+	"upload_snapshots": true,
+	"price_history":    true,
+	"change_log":       true,
+	"record_events":    true,
+	"vehicle_aliases":  true,
+	"record_reviews":   true,
+}
+
+// Первые сегменты после /api, занятые общими маршрутами
+var reservedRouteSegments = map[string]bool{
+	"vin":         true,
+	"vehicles":    true,
+	"search":      true,
+	"price-drops": true,
+	"depots":      true,
+	"geo":         true,
+	"aliases":     true,
+}
+
+// Первые сегменты маршрутов, которые RegisterSourceRoutes монтирует под префиксом источника
+var sourceRouteSegments = []string{
+	"upload",
+	"records",
+	"inventory",
+	"search",
+	"stats",
+	"files",
+	"clear-changed-columns",
+	"reviews",
+	"delete-all-records",
+	"export",
+	"price-history",
+	"change-log",
+	"events",
+}
+
+// Загрузка источников: встроенные плюс *.yaml, *.yml и *.json из каталога dir
+func loadSources(dir string) ([]*SourceDefinition, error) {
+	loaded := append([]*SourceDefinition{}, builtinSources...)
+	if dir == "" {
+		return loaded, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read sources dir: %w", err)
+	}
+
+	var problems []string
+	seen := make(map[string]string)
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		def, err := readSourceFile(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+		applySourceDefaults(def)
+		if other, ok := seen[def.Name]; ok {
+			problems = append(problems, fmt.Sprintf("%s: name %q is already defined in %s", path, def.Name, other))
+			continue
+		}
+		seen[def.Name] = path
+		for _, problem := range validateSource(def) {
+			problems = append(problems, fmt.Sprintf("%s: %s", path, problem))
+		}
+
+		replaced := false
+		for i, existing := range loaded {
+			if existing.Name == def.Name {
+				loaded[i] = def
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			loaded = append(loaded, def)
+		}
+	}
+
+	problems = append(problems, validateSourceSet(loaded)...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid source definitions:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return loaded, nil
+}
+
+// JSON — подмножество YAML, поэтому оба формата читаются одним декодером
+func readSourceFile(path string) (*SourceDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var def SourceDefinition
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&def); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	return &def, nil
+}
+
+func applySourceDefaults(def *SourceDefinition) {
+	if def.RoutePrefix == "" && def.Name != "" {
+		def.RoutePrefix = "/api/" + def.Name
+	}
+	if def.ExportFileName == "" && def.Table != "" {
+		def.ExportFileName = def.Table + ".xlsx"
+	}
+	for i := range def.Fields {
+		f := &def.Fields[i]
+		if f.Type == "" {
+			f.Type = fieldTypeText
+			if f.Name == def.PriceField {
+				f.Type = fieldTypeNumeric
+			}
+		}
+	}
+}
+
+// Проверка одного описания; возвращает список найденных ошибок
+func validateSource(def *SourceDefinition) []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !identifierPattern.MatchString(def.Name) {
+		addf("name %q must match %s", def.Name, identifierPattern)
+	}
+	if !identifierPattern.MatchString(def.Table) {
+		addf("table %q must match %s", def.Table, identifierPattern)
+	} else if reservedTables[def.Table] {
+		addf("table %q is reserved", def.Table)
+	}
+	if !routePrefixPattern.MatchString(def.RoutePrefix) {
+		addf("route_prefix %q must match %s", def.RoutePrefix, routePrefixPattern)
+	} else if segment, _, _ := strings.Cut(strings.TrimPrefix(def.RoutePrefix, "/api/"), "/"); reservedRouteSegments[segment] {
+		addf("route_prefix %q collides with the global /api/%s routes", def.RoutePrefix, segment)
+	}
+
+	if len(def.Fields) == 0 {
+		addf("fields must not be empty")
+	}
+	fields := make(map[string]bool, len(def.Fields))
+	for i, f := range def.Fields {
+		switch {
+		case !identifierPattern.MatchString(f.Name):
+			addf("fields[%d].name %q must match %s", i, f.Name, identifierPattern)
+		case reservedColumns[f.Name]:
+			addf("fields[%d].name %q is reserved", i, f.Name)
+		case fields[f.Name]:
+			addf("fields[%d].name %q is duplicated", i, f.Name)
+		}
+		fields[f.Name] = true
+
+		if len(f.Headers) == 0 {
+			addf("fields[%d] (%s): headers must not be empty", i, f.Name)
+		}
+		switch f.Type {
+		case "", fieldTypeText, fieldTypeNumeric, fieldTypeInteger:
+		default:
+			addf("fields[%d] (%s): type %q must be one of %s, %s, %s", i, f.Name, f.Type, fieldTypeText, fieldTypeNumeric, fieldTypeInteger)
+		}
+		if f.Name == "vin" && f.numeric() {
+			addf("fields[%d] (vin): type must be %s", i, fieldTypeText)
+		}
+		for j, h := range f.Headers {
+			if normalizeHeader(h) == "" {
+				addf("fields[%d] (%s): headers[%d] is blank", i, f.Name, j)
+			}
+		}
+	}
+	if !fields["vin"] {
+		addf("fields must include \"vin\"")
+	}
+
+	if def.PriceField == "" {
+		addf("price_field is required")
+	} else if f, ok := def.field(def.PriceField); !ok {
+		addf("price_field %q is not among fields", def.PriceField)
+	} else if f.Type != fieldTypeNumeric {
+		addf("price_field %q must have type %s", def.PriceField, fieldTypeNumeric)
+	}
+
+	if def.ActiveStatus != "" && def.StatusField == "" {
+		addf("active_status is set but status_field is empty")
+	}
+	if def.StatusField != "" {
+		if !fields[def.StatusField] {
+			addf("status_field %q is not among fields", def.StatusField)
+		}
+		if def.ActiveStatus == "" {
+			addf("status_field is set but active_status is empty")
+		}
+	}
+
+	for _, optional := range []struct{ key, value string }{
+		{"brand_field", def.BrandField},
+		{"year_field", def.YearField},
+		{"make_field", def.MakeField},
+		{"model_field", def.ModelField},
+		{"vehicle_type_field", def.VehicleTypeField},
+		{"city_field", def.CityField},
+		{"vehicle_subtype_field", def.VehicleSubtypeField},
+		{"days_on_sale_field", def.DaysOnSaleField},
+	} {
+		if optional.value != "" && !fields[optional.value] {
+			addf("%s %q is not among fields", optional.key, optional.value)
+		}
+	}
+	if f, ok := def.field(def.YearField); ok && f.Type != fieldTypeInteger {
+		addf("year_field %q must have type %s", def.YearField, fieldTypeInteger)
+	}
+	if f, ok := def.field(def.DaysOnSaleField); ok && !f.numeric() {
+		addf("days_on_sale_field %q must have type %s or %s", def.DaysOnSaleField, fieldTypeInteger, fieldTypeNumeric)
+	}
+
+	for i, name := range def.ComparedFields {
+		if !fields[name] {
+			addf("compared_fields[%d] %q is not among fields", i, name)
+		}
+	}
+
+	if strings.ContainsAny(def.ExportFileName, "/\\\";") {
+		addf("export_file_name %q contains forbidden characters", def.ExportFileName)
+	}
+	return problems
+}
+
+// Проверка уникальности имён, таблиц и префиксов между источниками
+func validateSourceSet(defs []*SourceDefinition) []string {
+	var problems []string
+	check := func(kind string, value func(*SourceDefinition) string) {
+		owners := make(map[string][]string)
+		for _, def := range defs {
+			owners[value(def)] = append(owners[value(def)], def.Name)
+		}
+		keys := make([]string, 0, len(owners))
+		for k := range owners {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if len(owners[k]) > 1 {
+				problems = append(problems, fmt.Sprintf("%s %q is used by several sources: %s", kind, k, strings.Join(owners[k], ", ")))
+			}
+		}
+	}
+	check("table", func(d *SourceDefinition) string { return d.Table })
+	check("route_prefix", func(d *SourceDefinition) string { return d.RoutePrefix })
+
+	// Префикс не должен совпадать с маршрутом другого источника или лежать под ним:
+	// /api/records перекрыл бы /api/records/{vin} источника с префиксом /api
+	for _, def := range defs {
+		for _, other := range defs {
+			if other == def {
+				continue
+			}
+			for _, segment := range sourceRouteSegments {
+				route := other.RoutePrefix + "/" + segment
+				if def.RoutePrefix == route || strings.HasPrefix(def.RoutePrefix, route+"/") {
+					problems = append(problems, fmt.Sprintf("route_prefix %q of %s collides with %s routes of %s", def.RoutePrefix, def.Name, route, other.Name))
+				}
+			}
+		}
+	}
+	return problems
+}
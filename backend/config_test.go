@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+const testSourceYAML = `name: %[1]s
+table: leasing_records_%[1]s
+route_prefix: %[2]s
+fields:
+  - name: vin
+    headers: ["VIN"]
+  - name: price
+    headers: ["Цена"]
+    type: numeric
+price_field: price
+`
+
+func writeTestSource(t *testing.T, dir, name, prefix string) {
+	t.Helper()
+	data := fmt.Sprintf(testSourceYAML, name, prefix)
+	if err := os.WriteFile(filepath.Join(dir, name+".yaml"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadSourcesRejectsNestedRoutePrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		route  string
+	}{
+		{"/api/records", "/api/records"},
+		{"/api/v2/records", "/api/v2/records"},
+		{"/api/reviews", "/api/reviews"},
+		{"/api/price-history", "/api/price-history"},
+		{"/api/files/archive", "/api/files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestSource(t, dir, "v4", tt.prefix)
+
+			_, err := loadSources(dir)
+			if err == nil {
+				t.Fatalf("loadSources with route_prefix %s: expected error", tt.prefix)
+			}
+			if !strings.Contains(err.Error(), "collides with "+tt.route+" routes") {
+				t.Errorf("error = %v, want collision with %s", err, tt.route)
+			}
+		})
+	}
+}
+
+func TestLoadSourcesAcceptsSiblingRoutePrefix(t *testing.T) {
+	dir := t.TempDir()
+	writeTestSource(t, dir, "v4", "/api/v4")
+	writeTestSource(t, dir, "v5", "/api/v4-archive")
+
+	if _, err := loadSources(dir); err != nil {
+		t.Fatalf("loadSources: %v", err)
+	}
+}
+
+// Список сегментов для проверки префиксов должен покрывать все маршруты источника
+func TestSourceRouteSegmentsCoverRoutes(t *testing.T) {
+	known := make(map[string]bool, len(sourceRouteSegments))
+	for _, segment := range sourceRouteSegments {
+		known[segment] = true
+	}
+
+	r := mux.NewRouter()
+	RegisterSourceRoutes(r, v2Source)
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		segment, _, _ := strings.Cut(strings.TrimPrefix(tpl, v2Source.RoutePrefix+"/"), "/")
+		if !known[segment] {
+			t.Errorf("route %s: segment %q is missing from sourceRouteSegments", tpl, segment)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed geodata/gazetteer.yaml
+var embeddedGazetteer []byte
+
+type gazetteerRegion struct {
+	Name    string   `yaml:"name"`
+	Aliases []string `yaml:"aliases"`
+}
+
+type gazetteerSettlement struct {
+	Name    string   `yaml:"name"`
+	Region  string   `yaml:"region"`
+	Lat     float64  `yaml:"lat"`
+	Lon     float64  `yaml:"lon"`
+	Aliases []string `yaml:"aliases"`
+}
+
+// Склад или площадка компании
+type Depot struct {
+	Name string  `yaml:"name" json:"name"`
+	Lat  float64 `yaml:"lat" json:"lat"`
+	Lon  float64 `yaml:"lon" json:"lon"`
+}
+
+type gazetteer struct {
+	Regions     []gazetteerRegion     `yaml:"regions"`
+	Settlements []gazetteerSettlement `yaml:"settlements"`
+	Depots      []Depot               `yaml:"depots"`
+
+	regionIndex     map[string]string
+	settlementIndex map[string][]int
+}
+
+// Место, распознанное по тексту местонахождения; координаты только у населённого пункта
+type GeoPlace struct {
+	City   string   `json:"city,omitempty"`
+	Region string   `json:"region,omitempty"`
+	Lat    *float64 `json:"lat,omitempty"`
+	Lon    *float64 `json:"lon,omitempty"`
+}
+
+// Расстояние от машины до склада
+type DepotDistance struct {
+	Name       string  `json:"name"`
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// Справочник, загруженный при старте
+var places = mustParseGazetteer(embeddedGazetteer)
+
+// Сокращения типа населённого пункта перед названием
+var settlementPrefixes = []string{"город ", "гор. ", "г. ", "г.", "г ", "пгт. ", "пгт ", "рп ", "пос. ", "п. ", "с. ", "дер. ", "д. ", "ст. "}
+
+func mustParseGazetteer(data []byte) *gazetteer {
+	g, err := parseGazetteer(data)
+	if err != nil {
+		panic(fmt.Sprintf("embedded gazetteer: %v", err))
+	}
+	return g
+}
+
+func parseGazetteer(data []byte) (*gazetteer, error) {
+	var g gazetteer
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&g); err != nil {
+		return nil, err
+	}
+	for i, d := range g.Depots {
+		if d.Name == "" {
+			return nil, fmt.Errorf("depots[%d]: name is required", i)
+		}
+	}
+	for i, s := range g.Settlements {
+		if s.Name == "" || s.Region == "" {
+			return nil, fmt.Errorf("settlements[%d]: name and region are required", i)
+		}
+	}
+	g.index()
+	return &g, nil
+}
+
+func (g *gazetteer) index() {
+	g.regionIndex = make(map[string]string)
+	for _, r := range g.Regions {
+		for _, name := range append([]string{r.Name}, r.Aliases...) {
+			key := placeKey(name)
+			if _, ok := g.regionIndex[key]; !ok {
+				g.regionIndex[key] = r.Name
+			}
+		}
+	}
+	g.settlementIndex = make(map[string][]int)
+	for i, s := range g.Settlements {
+		for _, name := range append([]string{s.Name}, s.Aliases...) {
+			key := placeKey(name)
+			g.settlementIndex[key] = append(g.settlementIndex[key], i)
+		}
+	}
+}
+
+// Дополнение встроенного справочника файлом path; пустой путь — без изменений.
+// Склады из файла заменяют встроенный список целиком.
+func loadGazetteer(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	override, err := parseGazetteer(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	merged := &gazetteer{
+		Regions:     append([]gazetteerRegion{}, override.Regions...),
+		Settlements: append([]gazetteerSettlement{}, override.Settlements...),
+		Depots:      places.Depots,
+	}
+	if len(override.Depots) > 0 {
+		merged.Depots = override.Depots
+	}
+	regions := make(map[string]bool)
+	for _, r := range override.Regions {
+		regions[r.Name] = true
+	}
+	for _, r := range places.Regions {
+		if !regions[r.Name] {
+			merged.Regions = append(merged.Regions, r)
+		}
+	}
+	settlements := make(map[string]bool)
+	for _, s := range override.Settlements {
+		settlements[s.Name+"\x00"+s.Region] = true
+	}
+	for _, s := range places.Settlements {
+		if !settlements[s.Name+"\x00"+s.Region] {
+			merged.Settlements = append(merged.Settlements, s)
+		}
+	}
+	merged.index()
+	places = merged
+	return nil
+}
+
+// Ключ сравнения названий: нижний регистр, ё→е, без точек в конце и типа пункта в начале
+func placeKey(value string) string {
+	value = strings.ReplaceAll(strings.ToLower(value), "ё", "е")
+	value = strings.Join(strings.Fields(value), " ")
+	for _, prefix := range settlementPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			value = strings.TrimSpace(strings.TrimPrefix(value, prefix))
+			break
+		}
+	}
+	value = strings.ReplaceAll(value, "обл.", "обл")
+	value = strings.ReplaceAll(value, "кр.", "кр")
+	return strings.TrimRight(value, ". ")
+}
+
+// Каноническое название региона по любому его написанию
+func (g *gazetteer) regionName(value string) (string, bool) {
+	name, ok := g.regionIndex[placeKey(value)]
+	return name, ok
+}
+
+// Разбор текста местонахождения: "г. Москва", "Московская обл., Подольск", "МСК".
+// Части через запятую проверяются целиком, затем по первым словам.
+func (g *gazetteer) resolve(value string) GeoPlace {
+	var region string
+	var candidates []int
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == '/' || r == '(' || r == ')'
+	})
+	for _, part := range parts {
+		key := placeKey(part)
+		if key == "" {
+			continue
+		}
+		words := strings.Fields(key)
+		for n := len(words); n >= 1 && n >= len(words)-3; n-- {
+			prefix := strings.Join(words[:n], " ")
+			if found, ok := g.settlementIndex[prefix]; ok {
+				candidates = append(candidates, found...)
+				break
+			}
+			if name, ok := g.regionIndex[prefix]; ok {
+				if region == "" {
+					region = name
+				}
+				break
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return GeoPlace{Region: region}
+	}
+	best := candidates[0]
+	for _, i := range candidates {
+		if region != "" && g.Settlements[i].Region == region {
+			best = i
+			break
+		}
+	}
+	s := g.Settlements[best]
+	lat, lon := s.Lat, s.Lon
+	return GeoPlace{City: s.Name, Region: s.Region, Lat: &lat, Lon: &lon}
+}
+
+// Расстояние по дуге большого круга, км
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// То же расстояние выражением SQL от столбцов geo_lat/geo_lon до точки ($lat, $lon)
+func haversineSQL(latParam, lonParam string) string {
+	return fmt.Sprintf(`(6371 * 2 * asin(sqrt(
+       power(sin(radians(geo_lat - %[1]s) / 2), 2) +
+       cos(radians(%[1]s)) * cos(radians(geo_lat)) * power(sin(radians(geo_lon - %[2]s) / 2), 2))))`, latParam, lonParam)
+}
+
+// Расстояния от места до складов, ближайший первым
+func (p GeoPlace) depotDistances() []DepotDistance {
+	if p.Lat == nil || p.Lon == nil || len(places.Depots) == 0 {
+		return nil
+	}
+	distances := make([]DepotDistance, 0, len(places.Depots))
+	for _, d := range places.Depots {
+		km := haversineKm(*p.Lat, *p.Lon, d.Lat, d.Lon)
+		distances = append(distances, DepotDistance{Name: d.Name, DistanceKm: math.Round(km*10) / 10})
+	}
+	sort.Slice(distances, func(i, j int) bool { return distances[i].DistanceKm < distances[j].DistanceKm })
+	return distances
+}
+
+func depotByName(name string) (Depot, bool) {
+	for _, d := range places.Depots {
+		if strings.EqualFold(d.Name, name) {
+			return d, true
+		}
+	}
+	return Depot{}, false
+}
+
+// Точка поиска по радиусу: "55.75,37.61" или название населённого пункта
+func parseGeoPoint(value string) (float64, float64, error) {
+	if lat, lon, ok := strings.Cut(value, ","); ok {
+		la, err1 := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+		lo, err2 := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+		if err1 == nil && err2 == nil {
+			return la, lo, nil
+		}
+	}
+	place := places.resolve(value)
+	if place.Lat == nil {
+		return 0, 0, fmt.Errorf("unknown place %q", value)
+	}
+	return *place.Lat, *place.Lon, nil
+}
+
+// Местонахождение записи по полю city_field источника
+func geocodeRecord(def *SourceDefinition, rec *Record) {
+	rec.Geo = GeoPlace{}
+	if def.CityField != "" {
+		rec.Geo = places.resolve(rec.Values[def.CityField])
+	}
+}
+
+// Пересчёт местонахождения записей источника, например после обновления справочника
+func regeocodeStoredRecords(def *SourceDefinition) error {
+	if def.CityField == "" {
+		return nil
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s", selectColumns(def), def.Table))
+	if err != nil {
+		return err
+	}
+	var stale []Record
+	for rows.Next() {
+		rec, err := scanRecord(def, rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		before := rec.Geo
+		geocodeRecord(def, &rec)
+		if !sameGeoPlace(before, rec.Geo) {
+			stale = append(stale, rec)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, rec := range stale {
+		args := append(geoArgs(rec.Geo), rec.ID)
+		_, err := db.Exec(fmt.Sprintf("UPDATE %s SET geo_city = $1, geo_region = $2, geo_lat = $3, geo_lon = $4 WHERE id = $5", def.Table), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameGeoPlace(a, b GeoPlace) bool {
+	sameFloat := func(x, y *float64) bool {
+		return (x == nil && y == nil) || (x != nil && y != nil && *x == *y)
+	}
+	return a.City == b.City && a.Region == b.Region && sameFloat(a.Lat, b.Lat) && sameFloat(a.Lon, b.Lon)
+}
+
+// Столбцы местонахождения в порядке geoArgs
+var geoColumns = []string{"geo_city", "geo_region", "geo_lat", "geo_lon"}
+
+func geoArgs(p GeoPlace) []interface{} {
+	var lat, lon interface{}
+	if p.Lat != nil && p.Lon != nil {
+		lat, lon = *p.Lat, *p.Lon
+	}
+	return []interface{}{nullIfEmpty(p.City), nullIfEmpty(p.Region), lat, lon}
+}
+
+// GET /api/depots: склады из справочника
+func depotsHandler(w http.ResponseWriter, r *http.Request) {
+	depots := places.Depots
+	if depots == nil {
+		depots = []Depot{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": depots})
+}
+
+// GET /api/geo/resolve?q=: как будет распознано местонахождение
+func geoResolveHandler(w http.ResponseWriter, r *http.Request) {
+	place := places.resolve(r.URL.Query().Get("q"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"place":           place,
+		"depot_distances": place.depotDistances(),
+	})
+}
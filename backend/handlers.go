@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/xuri/excelize/v2"
+)
+
+// Маршруты источника: /upload, /records, /files и т.д. под его префиксом
+func RegisterSourceRoutes(r *mux.Router, def *SourceDefinition) {
+	p := def.RoutePrefix
+	r.HandleFunc(p+"/upload", uploadHandler(def)).Methods("POST")
+	r.HandleFunc(p+"/upload/confirm/{id:[0-9]+}", confirmPreviewHandler(def)).Methods("POST")
+	r.HandleFunc(p+"/records", getRecordsHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/records/{vin}", recordDetailHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/inventory", inventoryHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/search", searchHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/stats", statsHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/files", filesHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/files/{id:[0-9]+}", fileDetailHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/files/compare", compareUploadsHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/clear-changed-columns", clearChangedColumnsHandler(def)).Methods("POST")
+	r.HandleFunc(p+"/reviews", reviewRecordsHandler(def)).Methods("POST")
+	r.HandleFunc(p+"/reviews/{vin}", unreviewRecordHandler(def)).Methods("DELETE")
+	r.HandleFunc(p+"/delete-all-records", deleteAllRecordsHandler(def)).Methods("POST")
+	r.HandleFunc(p+"/export", exportExcelHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/price-history/{vin}", priceHistoryHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/change-log", changeLogHandler(def)).Methods("GET")
+	r.HandleFunc(p+"/events", recordEventsHandler(def)).Methods("GET")
+}
+
+func uploadHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseMultipartForm(32 << 20)
+		if err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Failed to get file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "Failed to read file", http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256(data)
+
+		upload := &Upload{
+			FileName:   header.Filename,
+			SHA256:     hex.EncodeToString(sum[:]),
+			SizeBytes:  int64(len(data)),
+			UploadedBy: requestUser(r),
+		}
+
+		policy, err := parseErrorPolicy(r.FormValue("on_error"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		full := formFlag(r, "full")
+
+		if r.FormValue("mode") == "preview" || formFlag(r, "preview") {
+			previewUpload(w, def, upload, data, policy, full)
+			return
+		}
+
+		// Тот же файл уже загружался: отдаём прежний результат, если не просили force
+		if !formFlag(r, "force") {
+			original, outcome, found, err := findUploadByHash(def, upload.SHA256)
+			if err != nil {
+				http.Error(w, "Failed to check upload history", http.StatusInternalServerError)
+				return
+			}
+			if found {
+				var payload map[string]json.RawMessage
+				if err := json.Unmarshal(outcome, &payload); err != nil || payload == nil {
+					payload = map[string]json.RawMessage{}
+				}
+				for _, key := range []string{"records", "deleted", "withdrawn", "relisted", "errors"} {
+					if _, ok := payload[key]; !ok {
+						payload[key] = json.RawMessage("[]")
+					}
+				}
+				encodedUpload, _ := json.Marshal(original)
+				encodedName, _ := json.Marshal(upload.FileName)
+				payload["upload"] = encodedUpload
+				payload["file_name"] = encodedName
+				payload["duplicate"] = json.RawMessage("true")
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(payload)
+				return
+			}
+		}
+
+		importUpload(w, def, upload, data, policy, full, nil)
+	}
+}
+
+// Импорт файла в одной транзакции с записью в историю загрузок; false при ошибке
+// claim выполняется в транзакции импорта сразу после создания загрузки; её ошибка
+// отменяет импорт (errPreviewConfirmed — с ответом 409)
+func importUpload(w http.ResponseWriter, def *SourceDefinition, upload *Upload, data []byte, policy string, full bool,
+	claim func(tx dbExecutor, uploadID int) error) bool {
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		failUpload(w, def, upload, nil, "Failed to read Excel file", err, http.StatusBadRequest)
+		return false
+	}
+	defer f.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return false
+	}
+	defer tx.Rollback()
+
+	if err := createUpload(tx, def, upload); err != nil {
+		log.Printf("Failed to create upload %s: %v", def.Name, err)
+		http.Error(w, "Failed to save upload", http.StatusInternalServerError)
+		return false
+	}
+	if claim != nil {
+		if err := claim(tx, upload.ID); err != nil {
+			if errors.Is(err, errPreviewConfirmed) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return false
+			}
+			log.Printf("Failed to claim %s upload: %v", def.Name, err)
+			http.Error(w, "Failed to save upload", http.StatusInternalServerError)
+			return false
+		}
+	}
+
+	run := &importRun{tx: tx, def: def, uploadID: upload.ID, policy: policy, full: full}
+	result, err := processExcel(run, f)
+	if err != nil {
+		tx.Rollback()
+		upload.ID = 0
+		upload.Status = ""
+		failUpload(w, def, upload, result, fmt.Sprintf("Failed to process Excel: %v", err), err, importErrorStatus(err))
+		return false
+	}
+
+	upload.Status = uploadStatusSuccess
+	upload.countResult(result)
+	if err := finishUpload(tx, upload, result); err != nil {
+		log.Printf("Failed to save upload %s: %v", def.Name, err)
+		http.Error(w, "Failed to save upload", http.StatusInternalServerError)
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit import", http.StatusInternalServerError)
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"records":   result.Records,
+		"deleted":   result.Deleted,
+		"withdrawn": result.Withdrawn,
+		"relisted":  result.Relisted,
+		"errors":    result.Errors,
+		"file_name": upload.FileName,
+		"upload":    upload,
+		"duplicate": false,
+	})
+	return true
+}
+
+// HTTP-статус для ошибки processExcel
+func importErrorStatus(err error) int {
+	var missingErr *missingColumnsError
+	var abortedErr *importAbortedError
+	switch {
+	case errors.As(err, &missingErr):
+		return http.StatusBadRequest
+	case errors.As(err, &abortedErr):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// Фиксация неудачной загрузки в истории и ответ клиенту.
+// Если есть ошибки строк, ответ — JSON со списком row_errors.
+func failUpload(w http.ResponseWriter, def *SourceDefinition, upload *Upload, result *importResult, message string, cause error, status int) {
+	upload.Status = uploadStatusFailed
+	upload.Error = cause.Error()
+	if result != nil {
+		// Применённые до ошибки строки откачены, в истории остаются только ошибки
+		result = &importResult{
+			Records:   []Record{},
+			Deleted:   []string{},
+			Withdrawn: []string{},
+			Relisted:  []string{},
+			Errors:    result.Errors,
+			RowsTotal: result.RowsTotal,
+		}
+		upload.countResult(result)
+	}
+	if err := saveUpload(db, def, upload, result); err != nil {
+		log.Printf("Failed to save upload %s: %v", def.Name, err)
+	}
+
+	if result == nil || len(result.Errors) == 0 {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      message,
+		"row_errors": result.Errors,
+		"upload":     upload,
+	})
+}
+
+// Записи источника; параметры фильтров, сортировки и страниц — в parseRecordQuery.
+// Без page, limit и cursor ответ — массив записей, как раньше.
+func getRecordsHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		view := recordViewAll
+		if def.ChangedOnly {
+			view = recordViewNewOrChanged
+		}
+		rq, err := parseRecordQuery(def, r, view)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeRecordQuery(w, rq)
+	}
+}
+
+// Весь текущий сток источника независимо от changed_only описания и очистки
+// отметок; view=all|new|changed|unchanged, ответ всегда постраничный
+func inventoryHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rq, err := parseRecordQuery(def, r, recordViewAll)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !rq.paged {
+			rq.paged, rq.page, rq.limit = true, 1, 100
+		}
+		writeRecordQuery(w, rq)
+	}
+}
+
+// Сброс отметок изменений у всех записей для всех пользователей — только администратор.
+// Обычный просмотр отмечается каждым пользователем через reviews.
+func clearChangedColumnsHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		result, err := db.Exec(fmt.Sprintf("UPDATE %s SET changed_columns = '{}', updated_at = CURRENT_TIMESTAMP", def.Table))
+		if err != nil {
+			http.Error(w, "Failed to clear changed_columns", http.StatusInternalServerError)
+			return
+		}
+
+		rowsAffected, _ := result.RowsAffected()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":       "Все значения в колонке changed_columns успешно очищены",
+			"rows_affected": rowsAffected,
+		})
+	}
+}
+
+func deleteAllRecordsHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Confirm string `json:"confirm"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if payload.Confirm != "delete" {
+			http.Error(w, "To delete all records, send JSON: {\"confirm\": \"delete\"}", http.StatusBadRequest)
+			return
+		}
+
+		// Записи не удаляются, а снимаются с продажи: история и связи с загрузками остаются.
+		// Событие cleared сбрасывает проверку повторной загрузки того же файла.
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		moves, err := transitionRecords(tx, def, 0, lifecycleWithdrawn, eventReasonCleared, activeCondition)
+		if err != nil {
+			log.Printf("Failed to withdraw records %s: %v", def.Name, err)
+			http.Error(w, "Failed to delete all records", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to delete all records", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":      "Все записи сняты с продажи",
+			"rows_deleted": len(moves),
+		})
+	}
+}
+
+func exportExcelHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY updated_at DESC", selectColumns(def), def.Table, activeCondition))
+		if err != nil {
+			http.Error(w, "Failed to fetch records", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		f := excelize.NewFile()
+		defer f.Close()
+
+		sheetName := "Sheet1"
+		index, _ := f.NewSheet(sheetName)
+		f.SetActiveSheet(index)
+
+		for i, header := range def.exportHeaders() {
+			cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+			f.SetCellValue(sheetName, cell, header)
+		}
+
+		rowNum := 2
+		for rows.Next() {
+			rec, err := scanRecord(def, rows)
+			if err != nil {
+				log.Println("Failed scan for export:", err)
+				continue
+			}
+
+			// Числовые поля выгружаются числами, чтобы в Excel работали сортировка и формулы
+			values := make([]interface{}, 0, len(def.Fields)+2)
+			for _, field := range def.Fields {
+				value := rec.Values[field.Name]
+				values = append(values, exportCellValue(field, value))
+				if field.Name == def.PriceField {
+					values = append(values, exportCellValue(field, rec.OldPrice), priceDifference(rec.OldPrice, value))
+				}
+			}
+
+			for i, val := range values {
+				cell, _ := excelize.CoordinatesToCellName(i+1, rowNum)
+				f.SetCellValue(sheetName, cell, val)
+			}
+			rowNum++
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", "attachment; filename="+def.ExportFileName)
+		f.Write(w)
+	}
+}
+
+// Разница между старой и текущей ценой для выгрузки
+func priceDifference(oldPrice, price string) interface{} {
+	oldVal, ok1 := numberValue(oldPrice)
+	val, ok2 := numberValue(price)
+	if !ok1 || !ok2 {
+		return ""
+	}
+	return math.Round((oldVal-val)*100) / 100
+}
+
+func exportCellValue(f SourceField, value string) interface{} {
+	if !f.numeric() {
+		return value
+	}
+	if n, ok := numberValue(value); ok {
+		return n
+	}
+	return value
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/xuri/excelize/v2"
+)
+
+// Политика обработки ошибок применения строк при импорте. Строки с недопустимым
+// VIN или числом не прерывают импорт ни при какой политике: они пропускаются
+// и попадают в отчёт.
+const (
+	// Первая же ошибка откатывает весь файл
+	errorPolicyAbort = "abort"
+	// Строка с ошибкой пропускается, остальные применяются
+	errorPolicySkip = "skip"
+)
+
+func parseErrorPolicy(value string) (string, error) {
+	switch value {
+	case "", errorPolicyAbort:
+		return errorPolicyAbort, nil
+	case errorPolicySkip:
+		return errorPolicySkip, nil
+	}
+	return "", fmt.Errorf("invalid on_error %q: expected %q or %q", value, errorPolicyAbort, errorPolicySkip)
+}
+
+// Ошибка обработки строки файла; Row — номер строки как в Excel
+type RowError struct {
+	Row    int    `json:"row"`
+	VIN    string `json:"vin,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Импорт прерван ошибкой строки при политике abort
+type importAbortedError struct {
+	RowError RowError
+}
+
+func (e *importAbortedError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.RowError.Row, e.RowError.Reason)
+}
+
+// Изменение значения поля при сравнении с базой
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Изменения одной существующей записи
+type RecordDiff struct {
+	VIN     string        `json:"vin"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// Итог обработки файла: новые и изменённые записи, переходы состояний, ошибки строк.
+// Deleted — VIN, переведённые в sold по статусу строки.
+type importResult struct {
+	Records   []Record
+	Changed   []RecordDiff
+	Deleted   []string
+	Withdrawn []string
+	Relisted  []string
+	Errors    []RowError
+	RowsTotal int
+}
+
+// Параметры одного прогона импорта
+type importRun struct {
+	tx  dbExecutor
+	def *SourceDefinition
+	// Загрузка, к которой относятся записи истории; 0 в предпросмотре
+	uploadID int
+	policy   string
+	// Полная выгрузка: машины, которых нет в файле, переходят в withdrawn
+	full bool
+	// Столбцы, найденные в файле; заполняется при разборе заголовков
+	cols columnMap
+	// Словарь марок и моделей, загружается при первой строке
+	normalizer *makeModelNormalizer
+}
+
+// Канонические марка и модель по словарю, прочитанному в транзакции прогона
+func (run *importRun) normalizeMakeModel(rec *Record) error {
+	if run.normalizer == nil {
+		n, err := loadMakeModelNormalizer(run.tx)
+		if err != nil {
+			return err
+		}
+		run.normalizer = n
+	}
+	run.normalizer.apply(run.def, rec)
+	return nil
+}
+
+// Обработка файла внутри транзакции run.tx. Фиксацию или откат выполняет вызывающий.
+func processExcel(run *importRun, f *excelize.File) (*importResult, error) {
+	tx, def, policy := run.tx, run.def, run.policy
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("no sheets found")
+	}
+
+	sheetName := sheets[0]
+	rows, err := f.GetRows(sheetName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("file must have at least header and one data row")
+	}
+
+	// Числовые поля читаются без форматирования ячейки: "1,234,567.00" из формата
+	// Excel и "1234567" из самого значения — одно и то же число
+	rawRows, err := f.GetRows(sheetName, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, err
+	}
+
+	cols, err := resolveColumns(rows[0], def.columnSpecs())
+	if err != nil {
+		return nil, err
+	}
+	run.cols = cols
+
+	result := &importResult{
+		Records:   make([]Record, 0),
+		Changed:   make([]RecordDiff, 0),
+		Deleted:   make([]string, 0),
+		Withdrawn: make([]string, 0),
+		Relisted:  make([]string, 0),
+		Errors:    make([]RowError, 0),
+	}
+	present := make([]string, 0, len(rows)-1)
+	// VIN строк, отклонённых из-за самого VIN
+	var unparsed []string
+
+	for i, row := range rows[1:] {
+		rowNum := i + 2
+		incoming := newRecord(def)
+		for _, field := range def.Fields {
+			if field.numeric() && i+1 < len(rawRows) {
+				incoming.Values[field.Name] = cols.value(rawRows[i+1], field.Name)
+				continue
+			}
+			incoming.Values[field.Name] = cols.value(row, field.Name)
+		}
+
+		vin := incoming.VIN()
+		if vin == "" {
+			continue
+		}
+		result.RowsTotal++
+
+		// Строка с недопустимым VIN не попадает в таблицу, но машина в файле есть:
+		// запись с таким VIN, сохранённым как есть или после приведения, не снимается с продажи
+		normalized, rowErr := normalizeVIN(vin)
+		if rowErr != nil {
+			unparsed = append(unparsed, vin, normalized)
+		} else {
+			vin = normalized
+			incoming.Values["vin"] = vin
+			present = append(present, vin)
+			rowErr = normalizeRecordValues(def, &incoming)
+		}
+		if rowErr != nil {
+			result.Errors = append(result.Errors, RowError{Row: rowNum, VIN: vin, Reason: rowErr.Error()})
+			continue
+		}
+
+		rowErr, err := applyRowIsolated(run, incoming, result)
+		if err != nil {
+			return nil, err
+		}
+		if rowErr == nil {
+			continue
+		}
+		reported := RowError{Row: rowNum, VIN: vin, Reason: rowErr.Error()}
+		result.Errors = append(result.Errors, reported)
+		if policy == errorPolicyAbort {
+			return result, &importAbortedError{RowError: reported}
+		}
+	}
+
+	// Без единого допустимого VIN в файле нечего сравнивать: не снимаем с продажи всё подряд
+	if run.full && len(present) > 0 {
+		moves, err := transitionRecords(tx, def, run.uploadID, lifecycleWithdrawn, eventReasonMissing,
+			activeCondition+" AND NOT (vin = ANY($1))", pq.Array(append(present, unparsed...)))
+		if err != nil {
+			return nil, fmt.Errorf("withdraw missing: %w", err)
+		}
+		result.Withdrawn = movedVINs(moves)
+	}
+
+	return result, nil
+}
+
+// Применение строки; в режиме skip — под точкой сохранения, чтобы ошибка строки
+// не прерывала транзакцию целиком. Первое значение — ошибка строки, второе — фатальная.
+func applyRowIsolated(run *importRun, incoming Record, result *importResult) (error, error) {
+	if run.policy != errorPolicySkip {
+		return applyRow(run, incoming, result), nil
+	}
+
+	if _, err := run.tx.Exec("SAVEPOINT import_row"); err != nil {
+		return nil, err
+	}
+	if rowErr := applyRow(run, incoming, result); rowErr != nil {
+		if _, err := run.tx.Exec("ROLLBACK TO SAVEPOINT import_row"); err != nil {
+			return nil, err
+		}
+		return rowErr, nil
+	}
+	if _, err := run.tx.Exec("RELEASE SAVEPOINT import_row"); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// Применение одной строки файла к таблице источника
+func applyRow(run *importRun, incoming Record, result *importResult) error {
+	tx, def := run.tx, run.def
+	vin := incoming.VIN()
+
+	if err := saveSnapshotRow(run, incoming); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	if def.ActiveStatus != "" && incoming.Values[def.StatusField] != def.ActiveStatus {
+		moves, err := transitionRecords(tx, def, run.uploadID, lifecycleSold, eventReasonStatus,
+			"vin = $1 AND lifecycle_status <> '"+lifecycleSold+"'", vin)
+		if err != nil {
+			return fmt.Errorf("sold: %w", err)
+		}
+		result.Deleted = append(result.Deleted, movedVINs(moves)...)
+		return nil
+	}
+
+	existing, exists, err := getRecordByVIN(tx, def, vin)
+	if err != nil {
+		return fmt.Errorf("lookup: %w", err)
+	}
+	if exists {
+		for _, name := range def.fieldNames() {
+			incoming.Values[name] = run.cols.keep(name, incoming.Values[name], existing.Values[name])
+		}
+	}
+
+	if err := recordPrice(run, vin, incoming.Values[def.PriceField]); err != nil {
+		return fmt.Errorf("price history: %w", err)
+	}
+
+	if !exists {
+		photos := searchPhotos(vin)
+		if photos == nil {
+			photos = []string{}
+		}
+
+		record := incoming
+		record.Photos = photos
+		record.IsNew = true
+		record.ChangedColumns = []string{}
+		decodeRecordVIN(def, &record)
+		geocodeRecord(def, &record)
+		if err := run.normalizeMakeModel(&record); err != nil {
+			return fmt.Errorf("aliases: %w", err)
+		}
+
+		id, err := insertRecord(tx, def, record)
+		if err != nil {
+			return fmt.Errorf("insert: %w", err)
+		}
+		record.ID = id
+		result.Records = append(result.Records, record)
+		return nil
+	}
+
+	// Снятая с продажи машина вернулась: та же запись, отдельное событие relisted
+	relisted := existing.Lifecycle == lifecycleWithdrawn || existing.Lifecycle == lifecycleSold
+	if relisted {
+		if _, err := transitionRecords(tx, def, run.uploadID, lifecycleRelisted, eventReasonReturned, "vin = $1", vin); err != nil {
+			return fmt.Errorf("relist: %w", err)
+		}
+	}
+
+	// VIN попадает в итог только после того, как строка применена целиком:
+	// ошибка ниже откатывает точку сохранения вместе с возвратом
+	changed := compareRecords(def, existing, incoming)
+
+	if len(changed) == 0 {
+		if relisted {
+			result.Relisted = append(result.Relisted, vin)
+		}
+		return nil
+	}
+
+	var oldPrice string
+	for _, col := range changed {
+		if col == def.PriceField {
+			oldPrice = existing.Values[def.PriceField]
+			break
+		}
+	}
+
+	photos := existing.Photos
+	if photos == nil {
+		photos = []string{}
+	}
+
+	record := incoming
+	record.ID = existing.ID
+	record.OldPrice = oldPrice
+	record.Photos = photos
+	record.IsNew = false
+	record.ChangedColumns = changed
+	record.Lifecycle = existing.Lifecycle
+	decodeRecordVIN(def, &record)
+	geocodeRecord(def, &record)
+	if err := run.normalizeMakeModel(&record); err != nil {
+		return fmt.Errorf("aliases: %w", err)
+	}
+	if relisted {
+		record.Lifecycle = lifecycleRelisted
+	}
+
+	if err := updateRecord(tx, def, record); err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+
+	diff := RecordDiff{VIN: vin, Changes: make([]FieldChange, 0, len(changed))}
+	for _, col := range changed {
+		diff.Changes = append(diff.Changes, FieldChange{Field: col, Old: existing.Values[col], New: incoming.Values[col]})
+	}
+	if err := logChanges(run, diff); err != nil {
+		return fmt.Errorf("change log: %w", err)
+	}
+	if relisted {
+		result.Relisted = append(result.Relisted, vin)
+	}
+	result.Records = append(result.Records, record)
+	result.Changed = append(result.Changed, diff)
+	return nil
+}
+
+// Список сравниваемых полей, значения которых отличаются
+func compareRecords(def *SourceDefinition, old, new Record) []string {
+	var changed []string
+	for _, name := range def.ComparedFields {
+		f, _ := def.field(name)
+		if !fieldValuesEqual(f, old.Values[name], new.Values[name]) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Состояния жизненного цикла записи источника
+const (
+	lifecycleActive    = "active"
+	lifecycleWithdrawn = "withdrawn"
+	lifecycleSold      = "sold"
+	lifecycleRelisted  = "relisted"
+)
+
+// Причины переходов между состояниями
+const (
+	// Статус строки в файле отличается от статуса «в продаже»
+	eventReasonStatus = "status"
+	// Машины нет в полной выгрузке
+	eventReasonMissing = "missing"
+	// Машина снова появилась в файле в продаже
+	eventReasonReturned = "returned"
+	// Все записи сняты через delete-all-records
+	eventReasonCleared = "cleared"
+)
+
+// Условие SQL для записей, которые сейчас в продаже
+const activeCondition = "lifecycle_status IN ('active', 'relisted')"
+
+// Столбец с временем последнего перехода в состояние
+var lifecycleTimestampColumns = map[string]string{
+	lifecycleWithdrawn: "withdrawn_at",
+	lifecycleSold:      "sold_at",
+	lifecycleRelisted:  "relisted_at",
+}
+
+// Переход записи между состояниями
+type RecordEvent struct {
+	ID             int       `json:"id"`
+	Source         string    `json:"source"`
+	VIN            string    `json:"vin"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status"`
+	Reason         string    `json:"reason"`
+	UploadID       *int      `json:"upload_id"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+// Запись, переведённая в новое состояние, и её прежнее состояние
+type lifecycleMove struct {
+	VIN  string
+	From string
+}
+
+func ensureRecordEventsTable() error {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS record_events (
+       id SERIAL PRIMARY KEY,
+       source TEXT NOT NULL,
+       vin TEXT NOT NULL,
+       status TEXT NOT NULL,
+       previous_status TEXT,
+       reason TEXT NOT NULL,
+       upload_id INTEGER REFERENCES uploads(id) ON DELETE SET NULL,
+       occurred_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS record_events_vin_idx ON record_events (source, vin, occurred_at);
+    CREATE INDEX IF NOT EXISTS record_events_status_idx ON record_events (source, status, occurred_at);
+    `)
+	return err
+}
+
+func validLifecycle(status string) bool {
+	switch status {
+	case lifecycleActive, lifecycleWithdrawn, lifecycleSold, lifecycleRelisted:
+		return true
+	}
+	return false
+}
+
+// Перевод записей, подходящих под where, в состояние to с записью событий.
+// Аргументы where нумеруются с $1.
+func transitionRecords(q dbExecutor, def *SourceDefinition, uploadID int, to, reason, where string, args ...interface{}) ([]lifecycleMove, error) {
+	assignments := "lifecycle_status = $%d, status_changed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP"
+	if column, ok := lifecycleTimestampColumns[to]; ok {
+		assignments += ", " + column + " = CURRENT_TIMESTAMP"
+	}
+	args = append(args, to)
+
+	rows, err := q.Query(fmt.Sprintf(`
+       WITH moved AS (
+          SELECT vin, lifecycle_status FROM %s WHERE %s FOR UPDATE
+       )
+       UPDATE %s t SET `+assignments+`
+       FROM moved WHERE t.vin = moved.vin
+       RETURNING t.vin, moved.lifecycle_status
+    `, def.Table, where, def.Table, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	moves := make([]lifecycleMove, 0)
+	for rows.Next() {
+		var m lifecycleMove
+		if err := rows.Scan(&m.VIN, &m.From); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		moves = append(moves, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(moves) == 0 {
+		return moves, nil
+	}
+
+	var upload interface{}
+	if uploadID != 0 {
+		upload = uploadID
+	}
+	stmt, err := q.Prepare(`
+       INSERT INTO record_events (source, vin, status, previous_status, reason, upload_id)
+       VALUES ($1, $2, $3, $4, $5, $6)
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	for _, m := range moves {
+		if _, err := stmt.Exec(def.Name, m.VIN, to, m.From, reason, upload); err != nil {
+			return nil, err
+		}
+	}
+	return moves, nil
+}
+
+func movedVINs(moves []lifecycleMove) []string {
+	vins := make([]string, 0, len(moves))
+	for _, m := range moves {
+		vins = append(vins, m.VIN)
+	}
+	return vins
+}
+
+// События жизненного цикла источника с фильтрами vin, status, upload_id, from, to
+func recordEventsHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, limit, err := parsePagination(r, 100, 1000)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		q := r.URL.Query()
+		conditions := []string{"source = $1"}
+		args := []interface{}{def.Name}
+		add := func(condition string, value interface{}) {
+			args = append(args, value)
+			conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+		}
+
+		if v := q.Get("vin"); v != "" {
+			add("vin = $%d", lookupVIN(v))
+		}
+		if v := q.Get("status"); v != "" {
+			if !validLifecycle(v) {
+				http.Error(w, fmt.Sprintf("invalid status %q", v), http.StatusBadRequest)
+				return
+			}
+			add("status = $%d", v)
+		}
+		if v := q.Get("upload_id"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid upload_id %q", v), http.StatusBadRequest)
+				return
+			}
+			add("upload_id = $%d", id)
+		}
+		if v := q.Get("from"); v != "" {
+			from, err := parseDateParam(v, false)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+				return
+			}
+			add("occurred_at >= $%d", from)
+		}
+		if v := q.Get("to"); v != "" {
+			to, err := parseDateParam(v, true)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+				return
+			}
+			add("occurred_at < $%d", to)
+		}
+
+		where := strings.Join(conditions, " AND ")
+
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM record_events WHERE "+where, args...).Scan(&total); err != nil {
+			http.Error(w, "Failed to fetch record events", http.StatusInternalServerError)
+			return
+		}
+
+		query := fmt.Sprintf(`
+           SELECT id, source, vin, status, COALESCE(previous_status, ''), reason, upload_id, occurred_at
+           FROM record_events WHERE %s
+           ORDER BY occurred_at DESC, id DESC
+           LIMIT $%d OFFSET $%d
+        `, where, len(args)+1, len(args)+2)
+		rows, err := db.Query(query, append(args, limit, (page-1)*limit)...)
+		if err != nil {
+			http.Error(w, "Failed to fetch record events", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		items := make([]RecordEvent, 0)
+		for rows.Next() {
+			var e RecordEvent
+			var uploadID sql.NullInt64
+			if err := rows.Scan(&e.ID, &e.Source, &e.VIN, &e.Status, &e.PreviousStatus, &e.Reason, &uploadID, &e.OccurredAt); err != nil {
+				http.Error(w, "Failed to fetch record events", http.StatusInternalServerError)
+				return
+			}
+			if uploadID.Valid {
+				id := int(uploadID.Int64)
+				e.UploadID = &id
+			}
+			items = append(items, e)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"items": items,
+			"total": total,
+			"page":  page,
+			"limit": limit,
+		})
+	}
+}
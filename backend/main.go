@@ -6,7 +6,6 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -15,10 +14,6 @@ import (
 )
 
 var db *sql.DB
-var uploadedFiles []string
-var uploadedFilesV2 []string
-var uploadedFilesV3 []string
-var filesMutex sync.RWMutex
 
 func main() {
 	var err error
@@ -27,6 +22,19 @@ func main() {
 	dbUser := getEnv("DB_USER", "postgres")
 	dbPassword := getEnv("DB_PASSWORD", "postgres")
 	dbName := getEnv("DB_NAME", "leasing")
+	sourcesDir := getEnv("SOURCES_DIR", "")
+
+	sources, err = loadSources(sourcesDir)
+	if err != nil {
+		log.Fatal("Failed to load sources: ", err)
+	}
+
+	if err := loadVINDecoder(getEnv("VIN_DECODER_FILE", "")); err != nil {
+		log.Fatal("Failed to load VIN decoder table: ", err)
+	}
+	if err := loadGazetteer(getEnv("GAZETTEER_FILE", "")); err != nil {
+		log.Fatal("Failed to load gazetteer: ", err)
+	}
 
 	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		dbHost, dbPort, dbUser, dbPassword, dbName)
@@ -52,17 +60,21 @@ func main() {
 
 	r := mux.NewRouter()
 
-	// V1 routes (первая вкладка)
-	RegisterV1Routes(r)
-
-	// V2 routes (вторая вкладка)
-	RegisterV2Routes(r)
-
-	RegisterV3Routes(r)
+	for _, def := range sources {
+		RegisterSourceRoutes(r, def)
+	}
+	r.HandleFunc("/api/vin/{vin}", vinDecodeHandler).Methods("GET")
+	r.HandleFunc("/api/vehicles", vehiclesHandler).Methods("GET")
+	r.HandleFunc("/api/vehicles/{vin}", vehicleDetailHandler).Methods("GET")
+	r.HandleFunc("/api/search/all", globalSearchHandler).Methods("GET")
+	r.HandleFunc("/api/price-drops", priceDropsHandler).Methods("GET")
+	r.HandleFunc("/api/depots", depotsHandler).Methods("GET")
+	r.HandleFunc("/api/geo/resolve", geoResolveHandler).Methods("GET")
+	RegisterAliasRoutes(r)
 
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
 	})
 
@@ -73,84 +85,72 @@ func main() {
 }
 
 func initDB() {
-	query := `
-    CREATE TABLE IF NOT EXISTS leasing_records (
-       id SERIAL PRIMARY KEY,
-       subject TEXT,
-       location TEXT,
-       subject_type TEXT,
-       vehicle_type TEXT,
-       vin TEXT UNIQUE NOT NULL,
-       year TEXT,
-       mileage TEXT,
-       days_on_sale TEXT,
-       approved_price TEXT,
-       old_price TEXT,
-       status TEXT,
-       photos TEXT[],
-       is_new BOOLEAN DEFAULT false,
-       changed_columns TEXT[],
-       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-       updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    );
-    `
-	_, err := db.Exec(query)
-	if err != nil {
-		log.Fatal("Failed to create table:", err)
-	}
-
-	query2 := `
-    CREATE TABLE IF NOT EXISTS leasing_records_v2 (
-       id SERIAL PRIMARY KEY,
-       brand TEXT,
-       model TEXT,
-       vin TEXT UNIQUE NOT NULL,
-       exposure_period TEXT,
-       vehicle_type TEXT,
-       vehicle_subtype TEXT,
-       year TEXT,
-       mileage TEXT,
-       city TEXT,
-       actual_price TEXT,
-       old_price TEXT,
-       status TEXT,
-       photos TEXT[],
-       is_new BOOLEAN DEFAULT false,
-       changed_columns TEXT[],
-       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-       updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    );
-    `
-	_, err = db.Exec(query2)
-	if err != nil {
-		log.Fatal("Failed to create table v2:", err)
-	}
-
-	query3 := `
-    CREATE TABLE IF NOT EXISTS leasing_records_v3 (
-       id SERIAL PRIMARY KEY,
-       brand TEXT,
-       model TEXT,
-       vin TEXT UNIQUE NOT NULL,
-       exposure_period TEXT,
-       vehicle_type TEXT,
-       vehicle_subtype TEXT,
-       year TEXT,
-       mileage TEXT,
-       city TEXT,
-       actual_price TEXT,
-       old_price TEXT,
-       status TEXT,
-       photos TEXT[],
-       is_new BOOLEAN DEFAULT false,
-       changed_columns TEXT[],
-       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-       updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    );
-    `
-	_, err = db.Exec(query3)
+	for _, def := range sources {
+		if err := ensureSourceTable(def); err != nil {
+			log.Fatalf("Failed to create table %s: %v", def.Table, err)
+		}
+	}
+
+	if err := ensureUploadTables(); err != nil {
+		log.Fatal("Failed to create uploads tables:", err)
+	}
+
+	if err := ensurePreviewTables(); err != nil {
+		log.Fatal("Failed to create previews table:", err)
+	}
+
+	if err := ensurePriceHistoryTable(); err != nil {
+		log.Fatal("Failed to create price history table:", err)
+	}
+
+	if err := ensureChangeLogTable(); err != nil {
+		log.Fatal("Failed to create change log table:", err)
+	}
+
+	if err := ensureRecordEventsTable(); err != nil {
+		log.Fatal("Failed to create record events table:", err)
+	}
+
+	if err := ensureAliasTable(); err != nil {
+		log.Fatal("Failed to create vehicle aliases table:", err)
+	}
+
+	if err := ensureReviewsTable(); err != nil {
+		log.Fatal("Failed to create record reviews table:", err)
+	}
+
+	if err := ensureUploadSnapshotsTable(); err != nil {
+		log.Fatal("Failed to create upload snapshots table:", err)
+	}
+
+	ensureSearchSupport()
+	for _, def := range sources {
+		if err := ensureSearchIndexes(def); err != nil {
+			log.Fatalf("Failed to create search indexes for %s: %v", def.Table, err)
+		}
+	}
+
+	for _, def := range sources {
+		if err := normalizeStoredVINs(def); err != nil {
+			log.Fatalf("Failed to normalize VINs in %s: %v", def.Table, err)
+		}
+		if err := redecodeStoredVINs(def); err != nil {
+			log.Fatalf("Failed to decode VINs in %s: %v", def.Table, err)
+		}
+		if err := regeocodeStoredRecords(def); err != nil {
+			log.Fatalf("Failed to geocode records in %s: %v", def.Table, err)
+		}
+	}
+
+	// Марки и модели пересчитываются по текущему описанию: make_field мог смениться
+	normalizer, err := loadMakeModelNormalizer(db)
 	if err != nil {
-		log.Fatal("Failed to create table v2:", err)
+		log.Fatal("Failed to load vehicle aliases:", err)
+	}
+	for _, def := range sources {
+		if _, err := renormalizeSource(db, normalizer, def); err != nil {
+			log.Fatalf("Failed to normalize makes and models in %s: %v", def.Table, err)
+		}
 	}
 }
 
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Типы полей источника
+const (
+	fieldTypeText    = "text"
+	fieldTypeNumeric = "numeric"
+	fieldTypeInteger = "integer"
+)
+
+// Единицы измерения, которые встречаются в числовых ячейках
+var numberSuffixes = []string{"руб.", "руб", "р.", "₽", "rub", "км", "km", "дн.", "дн"}
+
+var plainNumberPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// Тип столбца в таблице источника
+func (f SourceField) sqlType() string {
+	switch f.Type {
+	case fieldTypeNumeric:
+		return "NUMERIC"
+	case fieldTypeInteger:
+		return "INTEGER"
+	}
+	return "TEXT"
+}
+
+func (f SourceField) numeric() bool {
+	return f.Type == fieldTypeNumeric || f.Type == fieldTypeInteger
+}
+
+// Разбор числа в русском или английском формате: "1 234 567,50 руб.", "120 000 км",
+// "1,234,567.50", "950,000". Возвращает каноническую запись без разделителей разрядов
+// и незначащих нулей; пустое значение остаётся пустым.
+func parseNumber(value string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(value))
+	for _, suffix := range numberSuffixes {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, suffix))
+			break
+		}
+	}
+	// Разряды, разделённые пробелами, — русская запись: запятая в ней десятичная
+	spaced := strings.ContainsAny(s, " \u00a0\u202f'")
+	s = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "", "'", "").Replace(s)
+	if s == "" {
+		return "", nil
+	}
+
+	commas, dots := strings.Count(s, ","), strings.Count(s, ".")
+	switch {
+	case commas > 0 && dots > 0:
+		// Десятичный разделитель — тот, что встречается последним
+		if strings.LastIndex(s, ",") > strings.LastIndex(s, ".") {
+			s = strings.Replace(strings.ReplaceAll(s, ".", ""), ",", ".", 1)
+		} else {
+			s = strings.ReplaceAll(s, ",", "")
+		}
+	case commas > 1:
+		s = strings.ReplaceAll(s, ",", "")
+	case commas == 1:
+		// "950,000" и "120,000 км" — разделитель разрядов, как в прежних выгрузках;
+		// "12,5" и "1 234,567" — десятичная запятая
+		whole, fraction, _ := strings.Cut(s, ",")
+		if len(fraction) == 3 && !spaced && strings.TrimLeft(whole, "-0") != "" {
+			s = strings.Replace(s, ",", "", 1)
+		} else {
+			s = strings.Replace(s, ",", ".", 1)
+		}
+	case dots > 1:
+		s = strings.ReplaceAll(s, ".", "")
+	}
+
+	if !plainNumberPattern.MatchString(s) {
+		// Числовая ячейка Excel может прийти в экспоненциальной записи
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", fmt.Errorf("cannot parse %q as a number", value)
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return canonicalNumber(s), nil
+}
+
+// Без ведущих нулей целой части и хвостовых нулей дробной
+func canonicalNumber(s string) string {
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	intPart = strings.TrimLeft(intPart, "0")
+	if intPart == "" {
+		intPart = "0"
+	}
+	fracPart = strings.TrimRight(fracPart, "0")
+
+	s = intPart
+	if fracPart != "" {
+		s += "." + fracPart
+	}
+	if negative && s != "0" {
+		s = "-" + s
+	}
+	return s
+}
+
+// Значение поля в канонической записи его типа
+func normalizeFieldValue(f SourceField, value string) (string, error) {
+	if !f.numeric() {
+		return value, nil
+	}
+	n, err := parseNumber(value)
+	if err != nil {
+		return "", err
+	}
+	if f.Type == fieldTypeInteger && strings.Contains(n, ".") {
+		return "", fmt.Errorf("%q is not a whole number", value)
+	}
+	return n, nil
+}
+
+// Приведение числовых полей записи; ошибка называет первое неразобранное поле
+func normalizeRecordValues(def *SourceDefinition, rec *Record) error {
+	for _, f := range def.Fields {
+		value, err := normalizeFieldValue(f, rec.Values[f.Name])
+		if err != nil {
+			return fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		rec.Values[f.Name] = value
+	}
+	return nil
+}
+
+// Значение для параметра запроса: пустое число передаётся как NULL
+func fieldArg(f SourceField, value string) interface{} {
+	if f.numeric() && value == "" {
+		return nil
+	}
+	return value
+}
+
+// Равенство значений поля; числа сравниваются по значению, а не по записи
+func fieldValuesEqual(f SourceField, a, b string) bool {
+	if a == b {
+		return true
+	}
+	if !f.numeric() {
+		return false
+	}
+	na, errA := parseNumber(a)
+	nb, errB := parseNumber(b)
+	return errA == nil && errB == nil && na == nb
+}
+
+// Разбор канонического числа для вычислений и выгрузки
+func numberValue(value string) (float64, bool) {
+	if value == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return f, err == nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{"", "", false},
+		{"  ", "", false},
+		{"1500000", "1500000", false},
+		{"1 234 567,50 руб.", "1234567.5", false},
+		{"1 234 567", "1234567", false},
+		{"120 000 км", "120000", false},
+		{"1,234,567.50", "1234567.5", false},
+		{"1.234.567,50", "1234567.5", false},
+		{"950,000", "950000", false},
+		{"120,000 км", "120000", false},
+		{"-950,000", "-950000", false},
+		{"12,5", "12.5", false},
+		{"1 234,567", "1234.567", false},
+		{"0,500", "0.5", false},
+		{"1.5", "1.5", false},
+		{"1.234.567", "1234567", false},
+		{"007", "7", false},
+		{"2.50", "2.5", false},
+		{"1.5E+06", "1500000", false},
+		{"по запросу", "", true},
+		{"12-34", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseNumber(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseNumber(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseNumber(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeFieldValueInteger(t *testing.T) {
+	f := SourceField{Name: "mileage", Type: fieldTypeInteger}
+	if got, err := normalizeFieldValue(f, "120,000 км"); err != nil || got != "120000" {
+		t.Errorf("normalizeFieldValue(120,000 км) = %q, %v", got, err)
+	}
+	if _, err := normalizeFieldValue(f, "12,5"); err == nil {
+		t.Error("normalizeFieldValue(12,5) for integer field: expected error")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xuri/excelize/v2"
+)
+
+// Итог предпросмотра: что изменится при загрузке файла
+type PreviewDiff struct {
+	New       []Record     `json:"new"`
+	Changed   []RecordDiff `json:"changed"`
+	Deleted   []string     `json:"deleted"`
+	Withdrawn []string     `json:"withdrawn"`
+	Relisted  []string     `json:"relisted"`
+	Errors    []RowError   `json:"errors"`
+}
+
+func ensurePreviewTables() error {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS upload_previews (
+       id SERIAL PRIMARY KEY,
+       source TEXT NOT NULL,
+       file_name TEXT NOT NULL,
+       sha256 TEXT NOT NULL,
+       size_bytes BIGINT NOT NULL,
+       uploaded_by TEXT,
+       on_error TEXT NOT NULL,
+       content BYTEA NOT NULL,
+       diff JSONB,
+       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+       confirmed_upload_id INTEGER REFERENCES uploads(id) ON DELETE SET NULL
+    );
+    ALTER TABLE upload_previews ADD COLUMN IF NOT EXISTS full_upload BOOLEAN DEFAULT false;
+    `)
+	return err
+}
+
+// Прогон импорта в транзакции с откатом: те же сравнения, без записи в таблицу.
+// Ошибки строк собираются все, политика on_error применяется при подтверждении.
+func previewUpload(w http.ResponseWriter, def *SourceDefinition, upload *Upload, data []byte, policy string, full bool) {
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "Failed to read Excel file", http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		return
+	}
+	result, err := processExcel(&importRun{tx: tx, def: def, policy: errorPolicySkip, full: full}, f)
+	tx.Rollback()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to process Excel: %v", err), importErrorStatus(err))
+		return
+	}
+
+	diff := PreviewDiff{
+		New:       make([]Record, 0),
+		Changed:   result.Changed,
+		Deleted:   result.Deleted,
+		Withdrawn: result.Withdrawn,
+		Relisted:  result.Relisted,
+		Errors:    result.Errors,
+	}
+	for _, rec := range result.Records {
+		if rec.IsNew {
+			rec.ID = 0
+			diff.New = append(diff.New, rec)
+		}
+	}
+
+	encoded, err := json.Marshal(diff)
+	if err != nil {
+		http.Error(w, "Failed to encode preview", http.StatusInternalServerError)
+		return
+	}
+
+	var id int
+	err = db.QueryRow(`
+       INSERT INTO upload_previews
+       (source, file_name, sha256, size_bytes, uploaded_by, on_error, full_upload, content, diff)
+       VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+       RETURNING id
+    `, def.Name, upload.FileName, upload.SHA256, upload.SizeBytes, upload.UploadedBy, policy, full, data, string(encoded)).Scan(&id)
+	if err != nil {
+		log.Printf("Failed to save preview %s: %v", def.Name, err)
+		http.Error(w, "Failed to save preview", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"preview_id":  id,
+		"confirm_url": fmt.Sprintf("%s/upload/confirm/%d", def.RoutePrefix, id),
+		"file_name":   upload.FileName,
+		"summary": map[string]int{
+			"rows_total": result.RowsTotal,
+			"new":        len(diff.New),
+			"changed":    len(diff.Changed),
+			"deleted":    len(diff.Deleted),
+			"withdrawn":  len(diff.Withdrawn),
+			"relisted":   len(diff.Relisted),
+			"errors":     len(diff.Errors),
+		},
+		"diff": diff,
+	})
+}
+
+// Предпросмотр уже применён другим подтверждением
+var errPreviewConfirmed = errors.New("preview already confirmed")
+
+// Применение сохранённого предпросмотра. Сравнение выполняется заново
+// по текущему состоянию базы, поэтому результат может отличаться от diff.
+func confirmPreviewHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid preview id", http.StatusBadRequest)
+			return
+		}
+
+		var upload Upload
+		var policy string
+		var full bool
+		var data []byte
+		var confirmedID sql.NullInt64
+		err = db.QueryRow(`
+           SELECT file_name, sha256, size_bytes, COALESCE(uploaded_by, ''), on_error, COALESCE(full_upload, false), content, confirmed_upload_id
+           FROM upload_previews WHERE source = $1 AND id = $2
+        `, def.Name, id).Scan(&upload.FileName, &upload.SHA256, &upload.SizeBytes, &upload.UploadedBy, &policy, &full, &data, &confirmedID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Preview not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch preview", http.StatusInternalServerError)
+			return
+		}
+		if confirmedID.Valid {
+			http.Error(w, fmt.Sprintf("Preview already confirmed as upload %d", confirmedID.Int64), http.StatusConflict)
+			return
+		}
+		if user := requestUser(r); user != "" {
+			upload.UploadedBy = user
+		}
+
+		// Предпросмотр помечается в транзакции импорта: из двух одновременных
+		// подтверждений второе ждёт блокировки строки и получает 409
+		importUpload(w, def, &upload, data, policy, full, func(tx dbExecutor, uploadID int) error {
+			result, err := tx.Exec(`
+               UPDATE upload_previews SET confirmed_upload_id = $1
+               WHERE id = $2 AND confirmed_upload_id IS NULL
+            `, uploadID, id)
+			if err != nil {
+				return err
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				return errPreviewConfirmed
+			}
+			return nil
+		})
+	}
+}
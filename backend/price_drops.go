@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Снижение цены машины за окно по истории цен
+type PriceDrop struct {
+	Source      string   `json:"source"`
+	VIN         string   `json:"vin"`
+	RecordID    int      `json:"record_id"`
+	Brand       string   `json:"brand"`
+	Model       string   `json:"model"`
+	VehicleType string   `json:"vehicle_type"`
+	City        string   `json:"city"`
+	Region      string   `json:"region"`
+	Lifecycle   string   `json:"lifecycle_status"`
+	StartPrice  float64  `json:"start_price"`
+	Price       float64  `json:"price"`
+	Drop        float64  `json:"drop"`
+	DropPercent *float64 `json:"drop_percent"`
+	// Сумма всех снижений в окне и их число: цена могла снижаться за несколько загрузок
+	CumulativeDrop float64    `json:"cumulative_drop"`
+	Drops          int        `json:"drops"`
+	LastDropAt     *time.Time `json:"last_drop_at"`
+	HistoryURL     string     `json:"price_history_url"`
+}
+
+// Сортировки рейтинга
+var priceDropSorts = map[string]string{
+	"drop":       "price_drop",
+	"percent":    "drop_percent",
+	"cumulative": "cumulative_drop",
+	"last_drop":  "last_drop_at",
+}
+
+// GET /api/price-drops: машины, подешевевшие за окно days (по умолчанию 30) или from..to.
+// Начальная цена — последняя до начала окна, а если её нет — первая в окне.
+// Фильтры source, vehicle_type, region, brand, min_drop, min_percent, lifecycle=all;
+// sort=drop|percent|cumulative|last_drop (по убыванию)
+func priceDropsHandler(w http.ResponseWriter, r *http.Request) {
+	page, limit, err := parsePagination(r, 50, 500)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		if to, err = parseDateParam(v, true); err != nil {
+			http.Error(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	days := 30
+	if v := q.Get("days"); v != "" {
+		if days, err = strconv.Atoi(v); err != nil || days < 1 {
+			http.Error(w, fmt.Sprintf("invalid days %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	from := to.AddDate(0, 0, -days)
+	if v := q.Get("from"); v != "" {
+		if from, err = parseDateParam(v, false); err != nil {
+			http.Error(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	defs := sources
+	if v := q.Get("source"); v != "" {
+		defs = nil
+		for _, name := range strings.Split(v, ",") {
+			def, ok := sourceByName(name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown source %q", name), http.StatusBadRequest)
+				return
+			}
+			defs = append(defs, def)
+		}
+	}
+	if len(defs) == 0 {
+		writePriceDrops(w, []PriceDrop{}, 0, page, limit, from, to)
+		return
+	}
+	names := make([]string, 0, len(defs))
+	for _, def := range defs {
+		names = append(names, def.Name)
+	}
+
+	args := []interface{}{pq.Array(names), from, to}
+	var conditions []string
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "$?", fmt.Sprintf("$%d", len(args))))
+	}
+	if q.Get("lifecycle") != "all" {
+		conditions = append(conditions, activeCondition)
+	}
+	if v := q.Get("vehicle_type"); v != "" {
+		add("vehicle_type ILIKE '%' || $? || '%'", v)
+	}
+	if v := q.Get("brand"); v != "" {
+		add("(brand ILIKE $? OR brand_raw ILIKE '%' || $? || '%')", v)
+	}
+	if v := q.Get("region"); v != "" {
+		if name, ok := places.regionName(v); ok {
+			add("region = $?", name)
+		} else {
+			add("region ILIKE '%' || $? || '%'", v)
+		}
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"min_drop", "price_drop >= $?"},
+		{"min_percent", "drop_percent >= $?"},
+	} {
+		v := q.Get(bound.param)
+		if v == "" {
+			continue
+		}
+		n, err := parseNumber(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s: %v", bound.param, err), http.StatusBadRequest)
+			return
+		}
+		add(bound.condition, n)
+	}
+
+	sortKey := q.Get("sort")
+	if sortKey == "" {
+		sortKey = "drop"
+	}
+	orderBy, ok := priceDropSorts[sortKey]
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid sort %q", sortKey), http.StatusBadRequest)
+		return
+	}
+
+	where := "TRUE"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	ranked := fmt.Sprintf(`
+       WITH listings AS (%s
+       ),
+       observations AS (
+          SELECT source, vin, price, observed_at, id,
+                 LAG(price) OVER (PARTITION BY source, vin ORDER BY observed_at, id) AS previous
+          FROM price_history
+          WHERE source = ANY($1) AND price IS NOT NULL AND observed_at < $3
+       ),
+       windowed AS (
+          SELECT source, vin,
+                 COALESCE(
+                    (array_agg(price ORDER BY observed_at DESC, id DESC) FILTER (WHERE observed_at < $2))[1],
+                    (array_agg(price ORDER BY observed_at, id) FILTER (WHERE observed_at >= $2))[1]
+                 ) AS start_price,
+                 (array_agg(price ORDER BY observed_at DESC, id DESC) FILTER (WHERE observed_at >= $2))[1] AS price,
+                 COALESCE(SUM(previous - price) FILTER (WHERE observed_at >= $2 AND price < previous), 0) AS cumulative_drop,
+                 COUNT(*) FILTER (WHERE observed_at >= $2 AND price < previous) AS drops,
+                 MAX(observed_at) FILTER (WHERE observed_at >= $2 AND price < previous) AS last_drop_at
+          FROM observations
+          GROUP BY source, vin
+       ),
+       ranked AS (
+          SELECT w.source, w.vin, l.id, COALESCE(l.brand, '') AS brand, l.brand_raw, COALESCE(l.model, '') AS model,
+                 COALESCE(l.vehicle_type, '') AS vehicle_type, COALESCE(l.city, '') AS city, COALESCE(l.region, '') AS region,
+                 l.lifecycle_status, w.start_price, w.price,
+                 w.start_price - w.price AS price_drop,
+                 (w.start_price - w.price) * 100 / NULLIF(w.start_price, 0) AS drop_percent,
+                 w.cumulative_drop, w.drops, w.last_drop_at
+          FROM windowed w
+          JOIN listings l ON l.source = w.source AND l.vin = w.vin
+          WHERE w.price IS NOT NULL AND w.price < w.start_price
+       )`, vehicleListingsSQL(defs))
+
+	var total int
+	if err := db.QueryRow(ranked+" SELECT COUNT(*) FROM ranked WHERE "+where, args...).Scan(&total); err != nil {
+		log.Printf("Failed to count price drops: %v", err)
+		http.Error(w, "Failed to fetch price drops", http.StatusInternalServerError)
+		return
+	}
+
+	query := fmt.Sprintf(`%s
+       SELECT source, vin, id, brand, model, vehicle_type, city, region, lifecycle_status,
+              start_price::float8, price::float8, price_drop::float8, round(drop_percent, 2)::float8,
+              cumulative_drop::float8, drops, last_drop_at
+       FROM ranked WHERE %s
+       ORDER BY %s DESC NULLS LAST, source, vin
+       LIMIT $%d OFFSET $%d
+    `, ranked, where, orderBy, len(args)+1, len(args)+2)
+	rows, err := db.Query(query, append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		log.Printf("Failed to fetch price drops: %v", err)
+		http.Error(w, "Failed to fetch price drops", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	drops := make([]PriceDrop, 0)
+	for rows.Next() {
+		var d PriceDrop
+		if err := rows.Scan(&d.Source, &d.VIN, &d.RecordID, &d.Brand, &d.Model, &d.VehicleType, &d.City, &d.Region, &d.Lifecycle,
+			&d.StartPrice, &d.Price, &d.Drop, &d.DropPercent, &d.CumulativeDrop, &d.Drops, &d.LastDropAt); err != nil {
+			http.Error(w, "Failed to fetch price drops", http.StatusInternalServerError)
+			return
+		}
+		if def, ok := sourceByName(d.Source); ok {
+			d.HistoryURL = def.RoutePrefix + "/price-history/" + d.VIN
+		}
+		drops = append(drops, d)
+	}
+
+	writePriceDrops(w, drops, total, page, limit, from, to)
+}
+
+func writePriceDrops(w http.ResponseWriter, drops []PriceDrop, total, page, limit int, from, to time.Time) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": drops,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+		"from":  from,
+		"to":    to,
+	})
+}
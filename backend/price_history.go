@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Наблюдение цены VIN в одной загрузке
+type PricePoint struct {
+	Price      string    `json:"price"`
+	UploadID   *int      `json:"upload_id"`
+	FileName   string    `json:"file_name,omitempty"`
+	ObservedAt time.Time `json:"observed_at"`
+	// Цена отличается от предыдущего наблюдения
+	Changed bool `json:"changed"`
+}
+
+func ensurePriceHistoryTable() error {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS price_history (
+       id SERIAL PRIMARY KEY,
+       source TEXT NOT NULL,
+       vin TEXT NOT NULL,
+       price NUMERIC,
+       upload_id INTEGER REFERENCES uploads(id) ON DELETE SET NULL,
+       observed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS price_history_vin_idx ON price_history (source, vin, observed_at);
+    `)
+	if err != nil {
+		return err
+	}
+	// Таблица могла быть создана с ценой в TEXT
+	return migrateNumericColumn("price_history", SourceField{Name: "price", Type: fieldTypeNumeric})
+}
+
+// Запись наблюдённой цены строки файла
+func recordPrice(run *importRun, vin, price string) error {
+	var uploadID interface{}
+	if run.uploadID != 0 {
+		uploadID = run.uploadID
+	}
+	_, err := run.tx.Exec(`
+       INSERT INTO price_history (source, vin, price, upload_id) VALUES ($1, $2, $3, $4)
+    `, run.def.Name, vin, nullIfEmpty(price), uploadID)
+	return err
+}
+
+// Временной ряд цен VIN по всем загрузкам источника
+func fetchPriceHistory(def *SourceDefinition, vin string) ([]PricePoint, error) {
+	rows, err := db.Query(`
+       SELECT COALESCE(ph.price::text, ''), ph.upload_id, COALESCE(u.file_name, ''), ph.observed_at
+       FROM price_history ph
+       LEFT JOIN uploads u ON u.id = ph.upload_id
+       WHERE ph.source = $1 AND ph.vin = $2
+       ORDER BY ph.observed_at, ph.id
+    `, def.Name, vin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := make([]PricePoint, 0)
+	for rows.Next() {
+		var p PricePoint
+		var uploadID sql.NullInt64
+		if err := rows.Scan(&p.Price, &uploadID, &p.FileName, &p.ObservedAt); err != nil {
+			return nil, err
+		}
+		if uploadID.Valid {
+			id := int(uploadID.Int64)
+			p.UploadID = &id
+		}
+		if len(points) > 0 && points[len(points)-1].Price != p.Price {
+			p.Changed = true
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func priceHistoryHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vin := lookupVIN(mux.Vars(r)["vin"])
+
+		points, err := fetchPriceHistory(def, vin)
+		if err != nil {
+			http.Error(w, "Failed to fetch price history", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"source": def.Name,
+			"vin":    vin,
+			"points": points,
+		})
+	}
+}
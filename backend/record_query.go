@@ -0,0 +1,468 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Служебные столбцы, по которым можно сортировать записи
+var recordSortColumns = map[string]bool{
+	"id":                true,
+	"created_at":        true,
+	"updated_at":        true,
+	"old_price":         true,
+	"lifecycle_status":  true,
+	"status_changed_at": true,
+	"geo_city":          true,
+	"geo_region":        true,
+}
+
+// Режимы просмотра по отметкам is_new и changed_columns
+const (
+	recordViewAll       = "all"
+	recordViewNew       = "new"
+	recordViewChanged   = "changed"
+	recordViewUnchanged = "unchanged"
+	// Новые и изменённые вместе: прежнее поведение /records
+	recordViewNewOrChanged = "new_or_changed"
+)
+
+var recordViewConditions = map[string]string{
+	recordViewAll:          "",
+	recordViewNew:          "is_new",
+	recordViewChanged:      "(NOT is_new AND cardinality(changed_columns) > 0)",
+	recordViewUnchanged:    "(NOT is_new AND COALESCE(cardinality(changed_columns), 0) = 0)",
+	recordViewNewOrChanged: "(is_new OR cardinality(changed_columns) > 0)",
+}
+
+// Выборка записей источника по параметрам строки запроса
+type recordQuery struct {
+	def        *SourceDefinition
+	conditions []string
+	args       []interface{}
+	// Столбец сортировки; пустой — порядок по расстоянию, курсор недоступен
+	sortColumn string
+	desc       bool
+	orderBy    string
+	view       string
+	// Условие «просмотрено пользователем X-User»; пустое — пользователь не указан
+	reviewedExpr string
+	// Постраничный вывод запрошен через page, limit или cursor
+	paged  bool
+	page   int
+	limit  int
+	cursor *recordCursor
+}
+
+// Положение в выдаче: значение столбца сортировки и id последней записи страницы
+type recordCursor struct {
+	Value *string `json:"v"`
+	ID    int     `json:"id"`
+}
+
+func (c recordCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeRecordCursor(value string) (*recordCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c recordCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// Условие с плейсхолдерами $?, которые нумеруются по порядку values
+func (rq *recordQuery) add(condition string, values ...interface{}) {
+	for _, value := range values {
+		rq.args = append(rq.args, value)
+		condition = strings.Replace(condition, "$?", fmt.Sprintf("$%d", len(rq.args)), 1)
+	}
+	rq.conditions = append(rq.conditions, condition)
+}
+
+func (rq *recordQuery) where() string {
+	if len(rq.conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(rq.conditions, " AND ")
+}
+
+// Разбор параметров выборки:
+//
+//	lifecycle=withdrawn,sold|all   состояния (по умолчанию в продаже)
+//	<поле>=значение                равенство, повтор параметра — список IN
+//	<числовое поле>_min, _max      диапазон; price_* и year_* — поля цены и года источника
+//	view=all|new|changed|unchanged|new_or_changed, по умолчанию defaultView;
+//	changed_only=true|false — то же, что view=new_or_changed|all
+//	is_new=true|false
+//	unreviewed=true|false          не просмотренные (просмотренные) пользователем X-User
+//	region, near|depot + radius_km местонахождение
+//	sort=<столбец>|-<столбец>      по умолчанию -updated_at
+//	page, limit | cursor, limit    постранично
+func parseRecordQuery(def *SourceDefinition, r *http.Request, defaultView string) (*recordQuery, error) {
+	q := r.URL.Query()
+	rq := &recordQuery{def: def, view: defaultView}
+
+	if v := q.Get("lifecycle"); v != "" && v != "all" {
+		states := strings.Split(v, ",")
+		for _, state := range states {
+			if !validLifecycle(state) {
+				return nil, fmt.Errorf("invalid lifecycle %q", state)
+			}
+		}
+		rq.add("lifecycle_status = ANY($?)", pq.Array(states))
+	} else if v == "" {
+		rq.add(activeCondition)
+	}
+
+	for _, f := range def.Fields {
+		var values []string
+		for _, raw := range q[f.Name] {
+			if raw == "" {
+				continue
+			}
+			value, err := normalizeFieldValue(f, raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", f.Name, err)
+			}
+			values = append(values, value)
+		}
+		switch {
+		case len(values) == 1:
+			rq.add(f.Name+" = $?", values[0])
+		case len(values) > 1:
+			rq.add(fmt.Sprintf("%s = ANY($?::%s[])", f.Name, strings.ToLower(f.sqlType())), pq.Array(values))
+		}
+	}
+
+	type rangeParam struct{ param, column string }
+	var ranges []rangeParam
+	for _, f := range def.Fields {
+		if f.numeric() {
+			ranges = append(ranges, rangeParam{f.Name, f.Name})
+		}
+	}
+	for _, alias := range []rangeParam{{"price", def.PriceField}, {"year", def.YearField}} {
+		if alias.column != "" && alias.column != alias.param {
+			ranges = append(ranges, alias)
+		}
+	}
+	for _, rp := range ranges {
+		for _, bound := range []struct{ suffix, op string }{{"_min", ">="}, {"_max", "<="}} {
+			v := q.Get(rp.param + bound.suffix)
+			if v == "" {
+				continue
+			}
+			n, err := parseNumber(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", rp.param+bound.suffix, err)
+			}
+			rq.add(fmt.Sprintf("%s %s $?", rp.column, bound.op), n)
+		}
+	}
+
+	if v := q.Get("is_new"); v != "" {
+		isNew, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid is_new %q", v)
+		}
+		rq.add("is_new = $?", isNew)
+	}
+	if v := q.Get("changed_only"); v != "" {
+		changedOnly, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid changed_only %q", v)
+		}
+		rq.view = recordViewAll
+		if changedOnly {
+			rq.view = recordViewNewOrChanged
+		}
+	}
+	if v := q.Get("view"); v != "" {
+		rq.view = v
+	}
+	condition, ok := recordViewConditions[rq.view]
+	if !ok {
+		return nil, fmt.Errorf("invalid view %q: expected all, new, changed, unchanged or new_or_changed", rq.view)
+	}
+	if condition != "" {
+		rq.add(condition)
+	}
+
+	if user := requestUser(r); user != "" {
+		rq.reviewedExpr = reviewedSQL(def, user)
+	}
+	if v := q.Get("unreviewed"); v != "" {
+		unreviewed, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unreviewed %q", v)
+		}
+		if rq.reviewedExpr == "" {
+			return nil, fmt.Errorf("unreviewed requires X-User header")
+		}
+		if unreviewed {
+			rq.add(recordViewConditions[recordViewNewOrChanged] + " AND NOT " + rq.reviewedExpr)
+		} else {
+			rq.add(rq.reviewedExpr)
+		}
+	}
+
+	if v := q.Get("region"); v != "" {
+		if name, ok := places.regionName(v); ok {
+			rq.add("geo_region = $?", name)
+		} else {
+			rq.add("geo_region ILIKE '%' || $? || '%'", v)
+		}
+	}
+
+	// Поиск по радиусу от точки near или склада depot; без sort ближайшие первыми
+	var distance string
+	if q.Get("near") != "" || q.Get("depot") != "" {
+		var lat, lon float64
+		if name := q.Get("depot"); name != "" {
+			depot, ok := depotByName(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown depot %q", name)
+			}
+			lat, lon = depot.Lat, depot.Lon
+		} else {
+			var err error
+			if lat, lon, err = parseGeoPoint(q.Get("near")); err != nil {
+				return nil, fmt.Errorf("invalid near: %v", err)
+			}
+		}
+		radius, err := strconv.ParseFloat(q.Get("radius_km"), 64)
+		if err != nil || radius <= 0 {
+			return nil, fmt.Errorf("radius_km must be a positive number")
+		}
+		rq.args = append(rq.args, lat, lon, radius)
+		distance = haversineSQL(fmt.Sprintf("$%d", len(rq.args)-2), fmt.Sprintf("$%d", len(rq.args)-1))
+		rq.conditions = append(rq.conditions, fmt.Sprintf("geo_lat IS NOT NULL AND %s <= $%d", distance, len(rq.args)))
+	}
+
+	sortKey := q.Get("sort")
+	switch {
+	case sortKey == "" && distance != "":
+		rq.orderBy = distance + ", id"
+	default:
+		if sortKey == "" {
+			sortKey = "-updated_at"
+		}
+		rq.desc = strings.HasPrefix(sortKey, "-")
+		column := strings.TrimPrefix(sortKey, "-")
+		switch column {
+		case "price":
+			column = def.PriceField
+		case "year":
+			if def.YearField != "" {
+				column = def.YearField
+			}
+		}
+		if _, ok := def.field(column); !ok && !recordSortColumns[column] {
+			return nil, fmt.Errorf("invalid sort %q", sortKey)
+		}
+		rq.sortColumn = column
+		direction := "ASC"
+		if rq.desc {
+			direction = "DESC"
+		}
+		rq.orderBy = fmt.Sprintf("%s %s NULLS LAST, id %s", column, direction, direction)
+	}
+
+	if q.Get("page") != "" || q.Get("limit") != "" || q.Get("cursor") != "" {
+		page, limit, err := parsePagination(r, 100, 1000)
+		if err != nil {
+			return nil, err
+		}
+		rq.paged, rq.page, rq.limit = true, page, limit
+	}
+	if v := q.Get("cursor"); v != "" {
+		if rq.sortColumn == "" {
+			return nil, fmt.Errorf("cursor requires sort when searching by radius")
+		}
+		if q.Get("page") != "" {
+			return nil, fmt.Errorf("use either page or cursor")
+		}
+		cursor, err := decodeRecordCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		rq.cursor = cursor
+	}
+	return rq, nil
+}
+
+// Условие «после курсора» с учётом NULLS LAST и id как второго ключа
+func (rq *recordQuery) cursorCondition() (string, []interface{}) {
+	op := ">"
+	if rq.desc {
+		op = "<"
+	}
+	c := rq.cursor
+	n := len(rq.args)
+	if c.Value == nil {
+		return fmt.Sprintf("(%s IS NULL AND id %s $%d)", rq.sortColumn, op, n+1), []interface{}{c.ID}
+	}
+	return fmt.Sprintf("(%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND id %[2]s $%[4]d) OR %[1]s IS NULL)", rq.sortColumn, op, n+1, n+2),
+		[]interface{}{*c.Value, c.ID}
+}
+
+// Столбцы SELECT записи и отметка о просмотре текущим пользователем
+func (rq *recordQuery) selectColumns() string {
+	reviewed := "NULL::boolean"
+	if rq.reviewedExpr != "" {
+		reviewed = rq.reviewedExpr
+	}
+	return selectColumns(rq.def) + ", " + reviewed
+}
+
+// Разбор строки rq.selectColumns(); extra — столбцы, выбранные после них
+func (rq *recordQuery) scan(row rowScanner, extra ...interface{}) (Record, error) {
+	var reviewed sql.NullBool
+	rec, err := scanRecord(rq.def, extraScanner{row, append([]interface{}{&reviewed}, extra...)})
+	if reviewed.Valid {
+		rec.Reviewed = &reviewed.Bool
+	}
+	return rec, err
+}
+
+// Добавляет к сканируемым столбцам записи дополнительные
+type extraScanner struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func (s extraScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// Страница выборки: записи, общее количество и курсор следующей страницы
+type recordPage struct {
+	Records    []Record
+	Total      int
+	NextCursor string
+}
+
+func (rq *recordQuery) fetchPage() (recordPage, error) {
+	var page recordPage
+	where := rq.where()
+	if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", rq.def.Table, where), rq.args...).Scan(&page.Total); err != nil {
+		return page, err
+	}
+
+	args := append([]interface{}{}, rq.args...)
+	sortValue := "NULL::text"
+	if rq.sortColumn != "" {
+		sortValue = rq.sortColumn + "::text"
+	}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s", rq.selectColumns(), sortValue, rq.def.Table, where)
+	if rq.cursor != nil {
+		condition, cursorArgs := rq.cursorCondition()
+		query += " AND " + condition
+		args = append(args, cursorArgs...)
+	}
+	// Лишняя запись показывает, есть ли следующая страница
+	query += fmt.Sprintf(" ORDER BY %s LIMIT $%d", rq.orderBy, len(args)+1)
+	args = append(args, rq.limit+1)
+	if rq.cursor == nil {
+		query += fmt.Sprintf(" OFFSET $%d", len(args)+1)
+		args = append(args, (rq.page-1)*rq.limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	page.Records = make([]Record, 0, rq.limit)
+	var last recordCursor
+	hasMore := false
+	for rows.Next() {
+		var value *string
+		rec, err := rq.scan(rows, &value)
+		if err != nil {
+			return page, err
+		}
+		if len(page.Records) == rq.limit {
+			hasMore = true
+			break
+		}
+		page.Records = append(page.Records, rec)
+		last = recordCursor{Value: value, ID: rec.ID}
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+	if hasMore && rq.sortColumn != "" {
+		page.NextCursor = last.encode()
+	}
+	return page, nil
+}
+
+// Все записи выборки без постраничного вывода
+func (rq *recordQuery) fetchAll() ([]Record, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s",
+		rq.selectColumns(), rq.def.Table, rq.where(), rq.orderBy), rq.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]Record, 0)
+	for rows.Next() {
+		rec, err := rq.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// Ответ со страницей или, если постраничный вывод не запрошен, прежний массив записей
+func writeRecordQuery(w http.ResponseWriter, rq *recordQuery) {
+	if !rq.paged {
+		records, err := rq.fetchAll()
+		if err != nil {
+			http.Error(w, "Failed to fetch records", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+		return
+	}
+
+	page, err := rq.fetchPage()
+	if err != nil {
+		http.Error(w, "Failed to fetch records", http.StatusInternalServerError)
+		return
+	}
+	response := map[string]interface{}{
+		"items": page.Records,
+		"total": page.Total,
+		"limit": rq.limit,
+		"view":  rq.view,
+	}
+	if rq.cursor == nil {
+		response["page"] = rq.page
+	}
+	if page.NextCursor != "" {
+		response["next_cursor"] = page.NextCursor
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
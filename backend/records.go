@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Запись источника: значения полей описания плюс служебные столбцы
+type Record struct {
+	ID             int
+	Values         map[string]string
+	OldPrice       string
+	Photos         []string
+	IsNew          bool
+	ChangedColumns []string
+	// Просмотрена ли запись текущим пользователем; nil — пользователь неизвестен
+	Reviewed *bool
+	// Состояние жизненного цикла и время последних переходов
+	Lifecycle       string
+	StatusChangedAt *time.Time
+	WithdrawnAt     *time.Time
+	SoldAt          *time.Time
+	RelistedAt      *time.Time
+	// Расшифровка VIN и расхождения с маркой и годом из файла
+	Decoded       VINDecode
+	BrandMismatch bool
+	YearMismatch  bool
+	// Марка и модель по словарю псевдонимов; пустые — написание не сопоставлено
+	CanonicalMake  string
+	CanonicalModel string
+	// Населённый пункт и регион по справочнику; пустые — местонахождение не распознано
+	Geo GeoPlace
+
+	source *SourceDefinition
+}
+
+func newRecord(def *SourceDefinition) Record {
+	return Record{Values: make(map[string]string, len(def.Fields)), Lifecycle: lifecycleActive, source: def}
+}
+
+func (rec Record) VIN() string {
+	return rec.Values["vin"]
+}
+
+// Плоский JSON в порядке полей описания, как у прежних LeasingRecord*
+func (rec Record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"id":`)
+	buf.WriteString(fmt.Sprint(rec.ID))
+
+	writeField := func(key string, value interface{}) error {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(`,"` + key + `":`)
+		buf.Write(encoded)
+		return nil
+	}
+
+	for _, name := range rec.source.fieldNames() {
+		if err := writeField(name, rec.Values[name]); err != nil {
+			return nil, err
+		}
+	}
+	if rec.OldPrice != "" {
+		if err := writeField("old_price", rec.OldPrice); err != nil {
+			return nil, err
+		}
+	}
+	photos := rec.Photos
+	if photos == nil {
+		photos = []string{}
+	}
+	if err := writeField("photos", photos); err != nil {
+		return nil, err
+	}
+	if err := writeField("is_new", rec.IsNew); err != nil {
+		return nil, err
+	}
+	if len(rec.ChangedColumns) > 0 {
+		if err := writeField("changed_columns", rec.ChangedColumns); err != nil {
+			return nil, err
+		}
+	}
+	if rec.Reviewed != nil {
+		if err := writeField("reviewed", *rec.Reviewed); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeField("lifecycle_status", rec.Lifecycle); err != nil {
+		return nil, err
+	}
+	for _, ts := range []struct {
+		key   string
+		value *time.Time
+	}{
+		{"status_changed_at", rec.StatusChangedAt},
+		{"withdrawn_at", rec.WithdrawnAt},
+		{"sold_at", rec.SoldAt},
+		{"relisted_at", rec.RelistedAt},
+	} {
+		if ts.value == nil {
+			continue
+		}
+		if err := writeField(ts.key, ts.value); err != nil {
+			return nil, err
+		}
+	}
+	if rec.Decoded != (VINDecode{}) {
+		if err := writeField("vin_decoded", rec.Decoded); err != nil {
+			return nil, err
+		}
+	}
+	if rec.CanonicalMake != "" {
+		if err := writeField("canonical_make", rec.CanonicalMake); err != nil {
+			return nil, err
+		}
+	}
+	if rec.CanonicalModel != "" {
+		if err := writeField("canonical_model", rec.CanonicalModel); err != nil {
+			return nil, err
+		}
+	}
+	if rec.Geo.City != "" || rec.Geo.Region != "" {
+		if err := writeField("geo", rec.Geo); err != nil {
+			return nil, err
+		}
+		if distances := rec.Geo.depotDistances(); len(distances) > 0 {
+			if err := writeField("depot_distances", distances); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := writeField("brand_mismatch", rec.BrandMismatch); err != nil {
+		return nil, err
+	}
+	if err := writeField("year_mismatch", rec.YearMismatch); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Создание таблицы источника и добавление недостающих столбцов
+func ensureSourceTable(def *SourceDefinition) error {
+	columns := []string{"id SERIAL PRIMARY KEY"}
+	for _, f := range def.Fields {
+		if f.Name == "vin" {
+			columns = append(columns, "vin TEXT UNIQUE NOT NULL")
+			continue
+		}
+		columns = append(columns, f.Name+" "+f.sqlType())
+	}
+	columns = append(columns,
+		"old_price NUMERIC",
+		"photos TEXT[]",
+		"is_new BOOLEAN DEFAULT false",
+		"changed_columns TEXT[]",
+		"created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+		"updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+	)
+
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n       %s\n    )", def.Table, strings.Join(columns, ",\n       "))
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	// Служебные столбцы, появившиеся после первой версии таблицы
+	for _, column := range []string{
+		"withdrawn_at TIMESTAMP",
+		"lifecycle_status TEXT NOT NULL DEFAULT 'active'",
+		"status_changed_at TIMESTAMP",
+		"sold_at TIMESTAMP",
+		"relisted_at TIMESTAMP",
+		"vin_manufacturer TEXT",
+		"vin_brand TEXT",
+		"vin_country TEXT",
+		"vin_model_year INTEGER",
+		"vin_brand_mismatch BOOLEAN DEFAULT false",
+		"vin_year_mismatch BOOLEAN DEFAULT false",
+		"canonical_make TEXT",
+		"canonical_model TEXT",
+		"geo_city TEXT",
+		"geo_region TEXT",
+		"geo_lat DOUBLE PRECISION",
+		"geo_lon DOUBLE PRECISION",
+	} {
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s", def.Table, column)); err != nil {
+			return err
+		}
+	}
+
+	// Записи, отмеченные выбывшими до появления lifecycle_status
+	if _, err := db.Exec(fmt.Sprintf(`
+       UPDATE %s SET lifecycle_status = '%s', status_changed_at = withdrawn_at
+       WHERE lifecycle_status = '%s' AND withdrawn_at IS NOT NULL AND relisted_at IS NULL
+    `, def.Table, lifecycleWithdrawn, lifecycleActive)); err != nil {
+		return err
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_lifecycle_idx ON %s (lifecycle_status)", def.Table, def.Table)); err != nil {
+		return err
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_geo_region_idx ON %s (geo_region)", def.Table, def.Table)); err != nil {
+		return err
+	}
+
+	// Поля, добавленные в описание после создания таблицы
+	for _, f := range def.Fields {
+		if f.Name == "vin" {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", def.Table, f.Name, f.sqlType())); err != nil {
+			return err
+		}
+	}
+	if err := migrateNumericColumns(def); err != nil {
+		return err
+	}
+	return ensureRecordIndexes(def)
+}
+
+// Индексы под сортировку и фильтры выборки записей: время изменения,
+// числовые поля, тип техники и город
+func ensureRecordIndexes(def *SourceDefinition) error {
+	columns := []string{"updated_at", "created_at"}
+	for _, f := range def.Fields {
+		if f.numeric() {
+			columns = append(columns, f.Name)
+		}
+	}
+	for _, name := range []string{def.VehicleTypeField, def.CityField} {
+		if name != "" {
+			columns = append(columns, name)
+		}
+	}
+	for _, column := range columns {
+		if _, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_%s_idx ON %s (%s, id)", def.Table, column, def.Table, column)); err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_changed_idx ON %s (id) WHERE is_new OR cardinality(changed_columns) > 0", def.Table, def.Table))
+	return err
+}
+
+// Перевод числовых полей, созданных как TEXT, в NUMERIC/INTEGER.
+// Значения разбираются тем же кодом, что и при импорте; неразобранные становятся NULL.
+func migrateNumericColumns(def *SourceDefinition) error {
+	numeric := []SourceField{{Name: "old_price", Type: fieldTypeNumeric}}
+	for _, f := range def.Fields {
+		if f.numeric() {
+			numeric = append(numeric, f)
+		}
+	}
+
+	for _, f := range numeric {
+		if err := migrateNumericColumn(def.Table, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Перевод одного столбца table из TEXT в тип поля f; столбец другого типа не трогается
+func migrateNumericColumn(table string, f SourceField) error {
+	var dataType string
+	err := db.QueryRow(`
+       SELECT data_type FROM information_schema.columns
+       WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2
+    `, table, f.Name).Scan(&dataType)
+	if err != nil {
+		return fmt.Errorf("%s.%s: %w", table, f.Name, err)
+	}
+	if dataType != "text" {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(fmt.Sprintf("SELECT id, %s FROM %s WHERE %s IS NOT NULL", f.Name, table, f.Name))
+	if err != nil {
+		return err
+	}
+	values := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		values[id] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(fmt.Sprintf("UPDATE %s SET %s = $1 WHERE id = $2", table, f.Name))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for id, value := range values {
+		parsed, err := normalizeFieldValue(f, value)
+		if err != nil {
+			log.Printf("%s.%s id %d: %v, value dropped", table, f.Name, id, err)
+			parsed = ""
+		}
+		if parsed == value {
+			continue
+		}
+		if _, err := stmt.Exec(fieldArg(f, parsed), id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING NULLIF(%s, '')::%s",
+		table, f.Name, f.sqlType(), f.Name, strings.ToLower(f.sqlType())))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Список столбцов для SELECT, совпадающий по порядку со scanRecord
+func selectColumns(def *SourceDefinition) string {
+	columns := append([]string{"id"}, def.fieldNames()...)
+	columns = append(columns,
+		"old_price",
+		"COALESCE(photos, '{}')",
+		"is_new",
+		"COALESCE(changed_columns, '{}')",
+		"lifecycle_status",
+		"status_changed_at",
+		"withdrawn_at",
+		"sold_at",
+		"relisted_at",
+		"COALESCE(vin_manufacturer, '')",
+		"COALESCE(vin_brand, '')",
+		"COALESCE(vin_country, '')",
+		"COALESCE(vin_model_year, 0)",
+		"COALESCE(vin_brand_mismatch, false)",
+		"COALESCE(vin_year_mismatch, false)",
+		"COALESCE(canonical_make, '')",
+		"COALESCE(canonical_model, '')",
+		"COALESCE(geo_city, '')",
+		"COALESCE(geo_region, '')",
+		"geo_lat",
+		"geo_lon",
+	)
+	return strings.Join(columns, ", ")
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Общий интерфейс *sql.DB и *sql.Tx
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+func scanRecord(def *SourceDefinition, row rowScanner) (Record, error) {
+	rec := newRecord(def)
+	names := def.fieldNames()
+	values := make([]sql.NullString, len(names))
+	var oldPrice sql.NullString
+	var changedAt, withdrawnAt, soldAt, relistedAt sql.NullTime
+	var lat, lon sql.NullFloat64
+
+	dest := []interface{}{&rec.ID}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	dest = append(dest, &oldPrice, pq.Array(&rec.Photos), &rec.IsNew, pq.Array(&rec.ChangedColumns),
+		&rec.Lifecycle, &changedAt, &withdrawnAt, &soldAt, &relistedAt,
+		&rec.Decoded.Manufacturer, &rec.Decoded.Brand, &rec.Decoded.Country, &rec.Decoded.ModelYear,
+		&rec.BrandMismatch, &rec.YearMismatch, &rec.CanonicalMake, &rec.CanonicalModel,
+		&rec.Geo.City, &rec.Geo.Region, &lat, &lon)
+
+	if err := row.Scan(dest...); err != nil {
+		return rec, err
+	}
+
+	for i, name := range names {
+		rec.Values[name] = nullStringToString(values[i])
+	}
+	rec.OldPrice = nullStringToString(oldPrice)
+	if rec.Photos == nil {
+		rec.Photos = []string{}
+	}
+	if rec.ChangedColumns == nil {
+		rec.ChangedColumns = []string{}
+	}
+	rec.StatusChangedAt = nullTimePtr(changedAt)
+	rec.WithdrawnAt = nullTimePtr(withdrawnAt)
+	rec.SoldAt = nullTimePtr(soldAt)
+	rec.RelistedAt = nullTimePtr(relistedAt)
+	if lat.Valid && lon.Valid {
+		rec.Geo.Lat, rec.Geo.Lon = &lat.Float64, &lon.Float64
+	}
+	return rec, nil
+}
+
+func getRecordByVIN(q dbExecutor, def *SourceDefinition, vin string) (Record, bool, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE vin = $1", selectColumns(def), def.Table)
+	rec, err := scanRecord(def, q.QueryRow(query, vin))
+	if err == sql.ErrNoRows {
+		return rec, false, nil
+	}
+	if err != nil {
+		return rec, false, err
+	}
+	return rec, true, nil
+}
+
+func insertRecord(q dbExecutor, def *SourceDefinition, record Record) (int, error) {
+	names := def.fieldNames()
+	columns := append(append([]string{}, names...), "old_price", "photos", "is_new", "changed_columns")
+	columns = append(columns, vinDecodeColumns...)
+	columns = append(columns, "canonical_make", "canonical_model")
+	columns = append(columns, geoColumns...)
+
+	args := make([]interface{}, 0, len(columns))
+	for _, f := range def.Fields {
+		args = append(args, fieldArg(f, record.Values[f.Name]))
+	}
+	args = append(args, nullIfEmpty(record.OldPrice), pq.Array(record.Photos), record.IsNew, pq.Array(record.ChangedColumns))
+	args = append(args, vinDecodeArgs(record)...)
+	args = append(args, nullIfEmpty(record.CanonicalMake), nullIfEmpty(record.CanonicalModel))
+	args = append(args, geoArgs(record.Geo)...)
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id",
+		def.Table, strings.Join(columns, ", "), placeholders(1, len(columns)))
+
+	var id int
+	err := q.QueryRow(query, args...).Scan(&id)
+	return id, err
+}
+
+func updateRecord(q dbExecutor, def *SourceDefinition, record Record) error {
+	var assignments []string
+	var args []interface{}
+	for _, f := range def.Fields {
+		if f.Name == "vin" {
+			continue
+		}
+		args = append(args, fieldArg(f, record.Values[f.Name]))
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", f.Name, len(args)))
+	}
+	for _, extra := range []struct {
+		column string
+		value  interface{}
+	}{
+		{"old_price", nullIfEmpty(record.OldPrice)},
+		{"photos", pq.Array(record.Photos)},
+		{"is_new", record.IsNew},
+		{"changed_columns", pq.Array(record.ChangedColumns)},
+		{"canonical_make", nullIfEmpty(record.CanonicalMake)},
+		{"canonical_model", nullIfEmpty(record.CanonicalModel)},
+	} {
+		args = append(args, extra.value)
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", extra.column, len(args)))
+	}
+	for i, value := range vinDecodeArgs(record) {
+		args = append(args, value)
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", vinDecodeColumns[i], len(args)))
+	}
+	for i, value := range geoArgs(record.Geo) {
+		args = append(args, value)
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", geoColumns[i], len(args)))
+	}
+	args = append(args, record.VIN())
+
+	query := fmt.Sprintf("UPDATE %s SET %s, updated_at = CURRENT_TIMESTAMP WHERE vin = $%d",
+		def.Table, strings.Join(assignments, ", "), len(args))
+	_, err := q.Exec(query, args...)
+	return err
+}
+
+// Столбцы расшифровки VIN в порядке vinDecodeArgs
+var vinDecodeColumns = []string{"vin_manufacturer", "vin_brand", "vin_country", "vin_model_year", "vin_brand_mismatch", "vin_year_mismatch"}
+
+func vinDecodeArgs(record Record) []interface{} {
+	var year interface{}
+	if record.Decoded.ModelYear != 0 {
+		year = record.Decoded.ModelYear
+	}
+	return []interface{}{
+		nullIfEmpty(record.Decoded.Manufacturer),
+		nullIfEmpty(record.Decoded.Brand),
+		nullIfEmpty(record.Decoded.Country),
+		year,
+		record.BrandMismatch,
+		record.YearMismatch,
+	}
+}
+
+// Запись только расшифровки VIN, без отметки об изменении записи
+func saveVINDecode(q dbExecutor, def *SourceDefinition, record Record) error {
+	args := vinDecodeArgs(record)
+	assignments := make([]string, len(vinDecodeColumns))
+	for i, column := range vinDecodeColumns {
+		assignments[i] = fmt.Sprintf("%s = $%d", column, i+1)
+	}
+	args = append(args, record.VIN())
+	_, err := q.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE vin = $%d", def.Table, strings.Join(assignments, ", "), len(args)), args...)
+	return err
+}
+
+// Плейсхолдеры $from..$from+n-1 через запятую
+func placeholders(from, n int) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("$%d", from+i)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Отметки о просмотре изменений: у каждого пользователя (или команды, если она
+// работает под общим X-User) свои. Запись считается просмотренной, пока не изменится
+// после отметки.
+func ensureReviewsTable() error {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS record_reviews (
+       source TEXT NOT NULL,
+       vin TEXT NOT NULL,
+       reviewer TEXT NOT NULL,
+       upload_id INTEGER REFERENCES uploads(id) ON DELETE SET NULL,
+       reviewed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+       PRIMARY KEY (source, reviewer, vin)
+    );
+    `)
+	return err
+}
+
+// Условие SQL «запись просмотрена пользователем после последнего изменения».
+// Изменение — появление записи, запись в change_log или новая цена в price_history;
+// updated_at не годится: его сдвигают и смена состояния, и общая очистка отметок.
+// Значения подставляются литералами: условие стоит и в списке столбцов, и в WHERE,
+// а COUNT по той же выборке не должен получать лишних параметров.
+func reviewedSQL(def *SourceDefinition, reviewer string) string {
+	return fmt.Sprintf(`EXISTS (
+       SELECT 1 FROM record_reviews rv
+       WHERE rv.source = %[1]s AND rv.reviewer = %[2]s AND rv.vin = %[3]s.vin
+         AND rv.reviewed_at >= GREATEST('-infinity'::timestamp, %[3]s.created_at,
+           (SELECT MAX(cl.changed_at) FROM change_log cl WHERE cl.source = %[1]s AND cl.vin = %[3]s.vin),
+           (SELECT MAX(ph.observed_at) FROM (
+              SELECT observed_at, price, LAG(price) OVER (ORDER BY id) AS previous, ROW_NUMBER() OVER (ORDER BY id) AS n
+              FROM price_history WHERE source = %[1]s AND vin = %[3]s.vin
+           ) ph WHERE ph.n > 1 AND ph.price IS DISTINCT FROM ph.previous)))`,
+		pq.QuoteLiteral(def.Name), pq.QuoteLiteral(reviewer), def.Table)
+}
+
+// POST <prefix>/reviews: отметить просмотренными записи {"vins": [...]}
+// и/или все записи загрузки {"upload_id": N} от имени X-User
+func reviewRecordsHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reviewer := requestUser(r)
+		if reviewer == "" {
+			http.Error(w, "X-User header is required", http.StatusBadRequest)
+			return
+		}
+		var payload struct {
+			VINs     []string `json:"vins"`
+			UploadID *int     `json:"upload_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(payload.VINs) == 0 && payload.UploadID == nil {
+			http.Error(w, "vins or upload_id is required", http.StatusBadRequest)
+			return
+		}
+
+		vins := make([]string, 0, len(payload.VINs))
+		for _, v := range payload.VINs {
+			vins = append(vins, lookupVIN(v))
+		}
+		var uploadID interface{}
+		if payload.UploadID != nil {
+			if _, err := getUpload(def, *payload.UploadID); err != nil {
+				http.Error(w, "Upload not found", http.StatusNotFound)
+				return
+			}
+			uploadID = *payload.UploadID
+		}
+
+		// Отмечаются только существующие записи: VIN из списка и VIN, затронутые загрузкой
+		result, err := db.Exec(fmt.Sprintf(`
+           INSERT INTO record_reviews (source, reviewer, vin, upload_id)
+           SELECT $1, $2, t.vin, $4 FROM %s t
+           WHERE t.vin = ANY($3)
+              OR t.vin IN (SELECT vin FROM upload_records WHERE upload_id = $4)
+           ON CONFLICT (source, reviewer, vin)
+           DO UPDATE SET reviewed_at = CURRENT_TIMESTAMP, upload_id = EXCLUDED.upload_id
+        `, def.Table), def.Name, reviewer, pq.Array(vins), uploadID)
+		if err != nil {
+			log.Printf("Failed to save %s reviews: %v", def.Name, err)
+			http.Error(w, "Failed to save reviews", http.StatusInternalServerError)
+			return
+		}
+		reviewed, _ := result.RowsAffected()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"reviewer": reviewer,
+			"reviewed": reviewed,
+		})
+	}
+}
+
+// DELETE <prefix>/reviews/{vin}: снять свою отметку, чтобы запись снова подсвечивалась
+func unreviewRecordHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reviewer := requestUser(r)
+		if reviewer == "" {
+			http.Error(w, "X-User header is required", http.StatusBadRequest)
+			return
+		}
+		vin := lookupVIN(mux.Vars(r)["vin"])
+		result, err := db.Exec(`DELETE FROM record_reviews WHERE source = $1 AND reviewer = $2 AND vin = $3`, def.Name, reviewer, vin)
+		if err != nil {
+			http.Error(w, "Failed to delete review", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "Review not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Найденная запись и её релевантность
+type SearchHit struct {
+	Source string  `json:"source"`
+	Rank   float64 `json:"rank"`
+	Record Record  `json:"record"`
+}
+
+// Расширение pg_trgm установлено; без него нечёткий поиск заменяется ILIKE
+var trigramSearch = true
+
+func ensureSearchSupport() {
+	if _, err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm"); err != nil {
+		log.Printf("pg_trgm is not available, fuzzy search falls back to ILIKE: %v", err)
+		trigramSearch = false
+	}
+}
+
+// Текстовые столбцы, по которым ищутся записи источника
+func searchColumns(def *SourceDefinition) []string {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, name := range []string{"subject", def.BrandField, def.ModelField, def.VehicleTypeField, def.CityField} {
+		if f, ok := def.field(name); ok && !f.numeric() && !seen[name] {
+			columns = append(columns, name)
+			seen[name] = true
+		}
+	}
+	return append(columns, "canonical_make", "canonical_model", "geo_city")
+}
+
+// Текст для поиска одним выражением; то же выражение стоит в индексах
+func searchDocumentSQL(def *SourceDefinition) string {
+	parts := make([]string, 0)
+	for _, column := range searchColumns(def) {
+		parts = append(parts, fmt.Sprintf("COALESCE(%s, '')", column))
+	}
+	return "(" + strings.Join(parts, " || ' ' || ") + ")"
+}
+
+func searchVectorSQL(def *SourceDefinition) string {
+	return fmt.Sprintf("to_tsvector('russian'::regconfig, %s)", searchDocumentSQL(def))
+}
+
+// Индексы полнотекстового и нечёткого поиска по записям источника
+func ensureSearchIndexes(def *SourceDefinition) error {
+	statements := []string{
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_search_fts_idx ON %s USING gin (%s)", def.Table, def.Table, searchVectorSQL(def)),
+	}
+	if trigramSearch {
+		statements = append(statements,
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_search_trgm_idx ON %s USING gin (%s gin_trgm_ops)", def.Table, def.Table, searchDocumentSQL(def)),
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_vin_trgm_idx ON %s USING gin (vin gin_trgm_ops)", def.Table, def.Table),
+		)
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Фрагмент VIN из строки поиска для LIKE: без пробелов, в верхнем регистре, с экранированием
+func vinFragment(text string) string {
+	fragment := strings.ToUpper(strings.Join(strings.Fields(text), ""))
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(fragment)
+}
+
+// Поиск text среди записей выборки rq: совпадение по словам с учётом русской морфологии,
+// похожее написание и часть VIN; окончание VIN ценится выше вхождения в середине
+func (rq *recordQuery) search(text string, limit, offset int) ([]SearchHit, int, error) {
+	def := rq.def
+	args := append([]interface{}{}, rq.args...)
+	args = append(args, text, vinFragment(text))
+	qParam, vinParam := fmt.Sprintf("$%d::text", len(args)-1), fmt.Sprintf("$%d::text", len(args))
+
+	document := searchDocumentSQL(def)
+	tsquery := fmt.Sprintf("websearch_to_tsquery('russian'::regconfig, %s)", qParam)
+	fuzzyMatch := fmt.Sprintf("%s ILIKE '%%' || %s || '%%'", document, qParam)
+	fuzzyRank := "0"
+	if trigramSearch {
+		fuzzyMatch = fmt.Sprintf("%s <%% %s", qParam, document)
+		fuzzyRank = fmt.Sprintf("word_similarity(%s, %s)", qParam, document)
+	}
+	vinMatch := fmt.Sprintf("(length(%[1]s) >= 3 AND vin LIKE '%%' || %[1]s || '%%')", vinParam)
+
+	match := fmt.Sprintf("(%s @@ %s OR %s OR %s)", searchVectorSQL(def), tsquery, fuzzyMatch, vinMatch)
+	rank := fmt.Sprintf(`(ts_rank(%s, %s) + %s +
+       CASE WHEN length(%[4]s) >= 3 AND vin LIKE '%%' || %[4]s THEN 1
+            WHEN %[5]s THEN 0.5 ELSE 0 END)`, searchVectorSQL(def), tsquery, fuzzyRank, vinParam, vinMatch)
+	where := rq.where() + " AND " + match
+
+	var total int
+	if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", def.Table, where), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf("SELECT %s, %s::float8 AS rank FROM %s WHERE %s ORDER BY rank DESC, id LIMIT $%d OFFSET $%d",
+		rq.selectColumns(), rank, def.Table, where, len(args)+1, len(args)+2)
+	rows, err := db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	hits := make([]SearchHit, 0)
+	for rows.Next() {
+		hit := SearchHit{Source: def.Name}
+		rec, err := rq.scan(rows, &hit.Rank)
+		if err != nil {
+			return nil, 0, err
+		}
+		hit.Record = rec
+		hits = append(hits, hit)
+	}
+	return hits, total, rows.Err()
+}
+
+// Строка поиска и страница из параметров q, page, limit
+func parseSearchParams(r *http.Request) (string, int, int, error) {
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" {
+		return "", 0, 0, fmt.Errorf("q is required")
+	}
+	page, limit, err := parsePagination(r, 50, 200)
+	return text, page, limit, err
+}
+
+// GET <prefix>/search?q=: поиск по записям источника; остальные параметры — фильтры /records
+func searchHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		text, page, limit, err := parseSearchParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rq, err := parseRecordQuery(def, r, recordViewAll)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hits, total, err := rq.search(text, limit, (page-1)*limit)
+		if err != nil {
+			log.Printf("Failed to search %s: %v", def.Name, err)
+			http.Error(w, "Failed to search records", http.StatusInternalServerError)
+			return
+		}
+		writeSearchHits(w, hits, total, page, limit)
+	}
+}
+
+// GET /api/search/all?q=: поиск по всем источникам или по source=v1,v2
+func globalSearchHandler(w http.ResponseWriter, r *http.Request) {
+	text, page, limit, err := parseSearchParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	defs := sources
+	if v := r.URL.Query().Get("source"); v != "" {
+		defs = nil
+		for _, name := range strings.Split(v, ",") {
+			def, ok := sourceByName(name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown source %q", name), http.StatusBadRequest)
+				return
+			}
+			defs = append(defs, def)
+		}
+	}
+
+	// Из каждого источника берутся лучшие записи до конца страницы, затем общий порядок
+	hits := make([]SearchHit, 0)
+	total := 0
+	for _, def := range defs {
+		rq, err := parseRecordQuery(def, r, recordViewAll)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		found, count, err := rq.search(text, page*limit, 0)
+		if err != nil {
+			log.Printf("Failed to search %s: %v", def.Name, err)
+			http.Error(w, "Failed to search records", http.StatusInternalServerError)
+			return
+		}
+		hits = append(hits, found...)
+		total += count
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Rank > hits[j].Rank })
+
+	from := (page - 1) * limit
+	if from > len(hits) {
+		from = len(hits)
+	}
+	to := from + limit
+	if to > len(hits) {
+		to = len(hits)
+	}
+	writeSearchHits(w, hits[from:to], total, page, limit)
+}
+
+func writeSearchHits(w http.ResponseWriter, hits []SearchHit, total, page, limit int) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": hits,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+// Поле записи источника: столбец таблицы, ключ JSON и заголовки входного файла
+type SourceField struct {
+	Name string `yaml:"name"`
+	// Допустимые заголовки во входном файле; первый используется при выгрузке
+	Headers []string `yaml:"headers"`
+	// text (по умолчанию), numeric или integer; числа разбираются при импорте
+	Type string `yaml:"type"`
+	// Файл без этого столбца отклоняется. VIN, цена и статус обязательны всегда;
+	// отсутствующий необязательный столбец не меняет сохранённые значения.
+	Required bool `yaml:"required"`
+}
+
+// Описание источника (лизингодателя): таблица, поля и правила сравнения
+type SourceDefinition struct {
+	Name        string        `yaml:"name"`
+	Table       string        `yaml:"table"`
+	RoutePrefix string        `yaml:"route_prefix"`
+	Fields      []SourceField `yaml:"fields"`
+	// Поле статуса и значение, при котором машина считается в продаже.
+	// Пустое ActiveStatus — источник не передаёт статус.
+	StatusField  string `yaml:"status_field"`
+	ActiveStatus string `yaml:"active_status"`
+	PriceField   string `yaml:"price_field"`
+	// Поля марки и года выпуска для сверки с расшифровкой VIN; необязательны.
+	// Марка может быть свободным текстом, в котором ищется название.
+	BrandField string `yaml:"brand_field"`
+	YearField  string `yaml:"year_field"`
+	// Поле, в котором только название марки, для словаря марок и моделей; необязательно.
+	// Свободный текст вроде предмета лизинга сюда не подходит.
+	MakeField string `yaml:"make_field"`
+	// Поля модели, вида ТС и местонахождения для общего каталога машин; необязательны
+	ModelField       string `yaml:"model_field"`
+	VehicleTypeField string `yaml:"vehicle_type_field"`
+	CityField        string `yaml:"city_field"`
+	// Поля подвида ТС и срока в продаже (в днях) для статистики; необязательны
+	VehicleSubtypeField string `yaml:"vehicle_subtype_field"`
+	DaysOnSaleField     string `yaml:"days_on_sale_field"`
+	// Поля, изменение которых отмечается в changed_columns
+	ComparedFields []string `yaml:"compared_fields"`
+	ExportFileName string   `yaml:"export_file_name"`
+	// Список записей отдаёт только новые и изменённые
+	ChangedOnly bool `yaml:"changed_only"`
+}
+
+var v1Source = &SourceDefinition{
+	Name:        "v1",
+	Table:       "leasing_records",
+	RoutePrefix: "/api",
+	Fields: []SourceField{
+		{Name: "subject", Headers: []string{"Предмет лизинга", "Наименование предмета лизинга", "Предмет"}},
+		{Name: "location", Headers: []string{"Местонахождение", "Местонахождение предмета лизинга", "Местоположение"}},
+		{Name: "subject_type", Headers: []string{"Вид предмета лизинга", "Тип предмета лизинга"}},
+		{Name: "vehicle_type", Headers: []string{"Вид ТС", "Тип ТС", "Вид транспортного средства"}},
+		{Name: "vin", Headers: []string{"VIN", "VIN номер", "VIN-номер", "Идентификационный номер", "Идентификационный номер (VIN)"}},
+		{Name: "year", Headers: []string{"Год выпуска", "Год"}, Type: fieldTypeInteger},
+		{Name: "mileage", Headers: []string{"Пробег", "Пробег, км"}, Type: fieldTypeInteger},
+		{Name: "days_on_sale", Headers: []string{"Дни в продаже", "Количество дней в продаже", "Дней в продаже"}, Type: fieldTypeInteger},
+		{Name: "approved_price", Headers: []string{"Текущая цена", "Одобренная цена", "Цена продажи", "Цена"}, Type: fieldTypeNumeric},
+		{Name: "status", Headers: []string{"Статус", "Статус продажи"}},
+	},
+	StatusField:      "status",
+	ActiveStatus:     "В продаже",
+	PriceField:       "approved_price",
+	BrandField:       "subject",
+	YearField:        "year",
+	VehicleTypeField: "vehicle_type",
+	CityField:        "location",
+	DaysOnSaleField:  "days_on_sale",
+	ComparedFields:   []string{"subject", "subject_type", "vehicle_type", "mileage", "approved_price", "status"},
+	ExportFileName:   "leasing_records.xlsx",
+	ChangedOnly:      true,
+}
+
+var v2Source = &SourceDefinition{
+	Name:        "v2",
+	Table:       "leasing_records_v2",
+	RoutePrefix: "/api/v2",
+	Fields: []SourceField{
+		{Name: "brand", Headers: []string{"Марка", "Марка ТС"}},
+		{Name: "model", Headers: []string{"Модель", "Модель ТС"}},
+		{Name: "vin", Headers: []string{"VIN", "VIN номер", "VIN-номер", "Идентификационный номер", "Идентификационный номер (VIN)"}},
+		{Name: "exposure_period", Headers: []string{"Срок экспозиции (дн.)", "Срок экспозиции", "Срок экспозиции, дн."}, Type: fieldTypeInteger},
+		{Name: "vehicle_type", Headers: []string{"Вид ТС", "Тип ТС", "Вид транспортного средства"}},
+		{Name: "vehicle_subtype", Headers: []string{"Подвид ТС", "Подтип ТС"}},
+		{Name: "year", Headers: []string{"Год выпуска", "Год"}, Type: fieldTypeInteger},
+		{Name: "mileage", Headers: []string{"Пробег", "Пробег, км"}, Type: fieldTypeInteger},
+		{Name: "city", Headers: []string{"Город", "Местонахождение"}},
+		{Name: "actual_price", Headers: []string{"Текущая цена", "Актуальная цена", "Цена продажи", "Цена"}, Type: fieldTypeNumeric},
+	},
+	PriceField:          "actual_price",
+	BrandField:          "brand",
+	YearField:           "year",
+	MakeField:           "brand",
+	ModelField:          "model",
+	VehicleTypeField:    "vehicle_type",
+	CityField:           "city",
+	VehicleSubtypeField: "vehicle_subtype",
+	DaysOnSaleField:     "exposure_period",
+	ComparedFields:      []string{"brand", "model", "exposure_period", "vehicle_type", "vehicle_subtype", "year", "mileage", "city", "actual_price"},
+	ExportFileName:      "leasing_records_v2.xlsx",
+}
+
+var v3Source = &SourceDefinition{
+	Name:        "v3",
+	Table:       "leasing_records_v3",
+	RoutePrefix: "/api/v3",
+	Fields: []SourceField{
+		{Name: "brand", Headers: []string{"Марка", "Марка ТС"}},
+		{Name: "model", Headers: []string{"Модель", "Модель ТС"}},
+		{Name: "vin", Headers: []string{"VIN", "VIN номер", "VIN-номер", "Идентификационный номер", "Идентификационный номер (VIN)"}},
+		{Name: "exposure_period", Headers: []string{"Срок экспозиции (дн.)", "Срок экспозиции", "Срок экспозиции, дн."}, Type: fieldTypeInteger},
+		{Name: "vehicle_type", Headers: []string{"Вид ТС", "Тип ТС", "Вид транспортного средства"}},
+		{Name: "vehicle_subtype", Headers: []string{"Подвид ТС", "Подтип ТС"}},
+		{Name: "year", Headers: []string{"Год выпуска", "Год"}, Type: fieldTypeInteger},
+		{Name: "mileage", Headers: []string{"Пробег", "Пробег, км"}, Type: fieldTypeInteger},
+		{Name: "city", Headers: []string{"Город", "Местонахождение"}},
+		{Name: "actual_price", Headers: []string{"Текущая цена", "Актуальная цена", "Цена продажи", "Цена"}, Type: fieldTypeNumeric},
+		{Name: "status", Headers: []string{"Статус", "Статус продажи"}},
+	},
+	StatusField:         "status",
+	ActiveStatus:        "В свободной продаже",
+	PriceField:          "actual_price",
+	BrandField:          "brand",
+	YearField:           "year",
+	MakeField:           "brand",
+	ModelField:          "model",
+	VehicleTypeField:    "vehicle_type",
+	CityField:           "city",
+	VehicleSubtypeField: "vehicle_subtype",
+	DaysOnSaleField:     "exposure_period",
+	ComparedFields:      []string{"brand", "model", "exposure_period", "vehicle_type", "vehicle_subtype", "year", "mileage", "city", "actual_price", "status"},
+	ExportFileName:      "leasing_records_v3.xlsx",
+}
+
+// Встроенные источники; описания из SOURCES_DIR с тем же именем их заменяют
+var builtinSources = []*SourceDefinition{v1Source, v2Source, v3Source}
+
+// Источники, загруженные при старте
+var sources []*SourceDefinition
+
+func sourceByName(name string) (*SourceDefinition, bool) {
+	for _, def := range sources {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return nil, false
+}
+
+func (def *SourceDefinition) field(name string) (SourceField, bool) {
+	for _, f := range def.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return SourceField{}, false
+}
+
+// Имена полей в порядке описания
+func (def *SourceDefinition) fieldNames() []string {
+	names := make([]string, len(def.Fields))
+	for i, f := range def.Fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Описания столбцов входного файла для поиска по заголовкам
+func (def *SourceDefinition) columnSpecs() []columnSpec {
+	specs := make([]columnSpec, len(def.Fields))
+	for i, f := range def.Fields {
+		specs[i] = columnSpec{Field: f.Name, Aliases: f.Headers, Required: def.fieldRequired(f)}
+	}
+	return specs
+}
+
+func (def *SourceDefinition) fieldRequired(f SourceField) bool {
+	return f.Required || f.Name == "vin" || f.Name == def.PriceField || f.Name == def.StatusField
+}
+
+// Заголовки выгрузки: поля источника, после цены — старая цена и разница
+func (def *SourceDefinition) exportHeaders() []string {
+	headers := make([]string, 0, len(def.Fields)+2)
+	for _, f := range def.Fields {
+		headers = append(headers, f.Headers[0])
+		if f.Name == def.PriceField {
+			headers = append(headers, "Старая цена", "Разница")
+		}
+	}
+	return headers
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Показатели группы записей; цены и срок в продаже — по машинам в продаже,
+// новые и изменённые — по последней успешной загрузке источника
+type StatsBucket struct {
+	Key            string   `json:"key,omitempty"`
+	Active         int      `json:"active"`
+	New            int      `json:"new"`
+	Changed        int      `json:"changed"`
+	Withdrawn      int      `json:"withdrawn"`
+	Sold           int      `json:"sold"`
+	TotalPrice     *float64 `json:"total_price"`
+	MedianPrice    *float64 `json:"median_price"`
+	AvgDaysOnSale  *float64 `json:"avg_days_on_sale"`
+	WithPriceCount int      `json:"with_price"`
+}
+
+// Разрез статистики: имя в ответе и выражение по столбцам источника
+type statsDimension struct {
+	Name string
+	Expr string
+}
+
+// Разрезы, доступные источнику: марка — по словарю и VIN, город — по справочнику
+func statsDimensions(def *SourceDefinition) []statsDimension {
+	var dims []statsDimension
+	if def.VehicleTypeField != "" {
+		dims = append(dims, statsDimension{"vehicle_type", def.VehicleTypeField})
+	}
+	if def.VehicleSubtypeField != "" {
+		dims = append(dims, statsDimension{"vehicle_subtype", def.VehicleSubtypeField})
+	}
+	brand := "COALESCE(canonical_make, NULLIF(vin_brand, ''))"
+	if def.BrandField != "" {
+		brand = fmt.Sprintf("COALESCE(canonical_make, NULLIF(vin_brand, ''), %s)", def.BrandField)
+	}
+	dims = append(dims, statsDimension{"brand", brand})
+	if def.CityField != "" {
+		dims = append(dims, statsDimension{"city", fmt.Sprintf("COALESCE(geo_city, %s)", def.CityField)})
+	}
+	return dims
+}
+
+// Итог и разрезы одним запросом с GROUPING SETS; uploadID — загрузка, по которой
+// считаются новые и изменённые записи (0 — загрузок не было)
+func computeStats(def *SourceDefinition, uploadID int) (StatsBucket, map[string][]StatsBucket, error) {
+	dims := statsDimensions(def)
+	days := "NULL::numeric"
+	if def.DaysOnSaleField != "" {
+		days = def.DaysOnSaleField
+	}
+
+	baseColumns := make([]string, 0, len(dims))
+	sets := []string{"()"}
+	keyCases := make([]string, 0, len(dims))
+	keyValues := make([]string, 0, len(dims))
+	for i, d := range dims {
+		column := fmt.Sprintf("d%d", i)
+		baseColumns = append(baseColumns, fmt.Sprintf("NULLIF(TRIM(%s), '') AS %s", d.Expr, column))
+		sets = append(sets, "("+column+")")
+		keyCases = append(keyCases, fmt.Sprintf("WHEN GROUPING(%s) = 0 THEN '%s'", column, d.Name))
+		keyValues = append(keyValues, fmt.Sprintf("WHEN GROUPING(%s) = 0 THEN %s", column, column))
+	}
+	dimension, key := "''", "NULL::text"
+	if len(dims) > 0 {
+		dimension = "CASE " + strings.Join(keyCases, " ") + " ELSE '' END"
+		key = "CASE " + strings.Join(keyValues, " ") + " END"
+		baseColumns = append([]string{""}, baseColumns...)
+	}
+
+	query := fmt.Sprintf(`
+       WITH base AS (
+          SELECT %[1]s AS active,
+                 (SELECT action FROM upload_records ur
+                  WHERE ur.upload_id = $1 AND ur.vin = %[5]s.vin) AS upload_action,
+                 lifecycle_status,
+                 %[2]s::numeric AS price,
+                 %[3]s::numeric AS days%[4]s
+          FROM %[5]s
+       )
+       SELECT %[6]s AS dimension, COALESCE(%[7]s, '') AS key,
+              COUNT(*) FILTER (WHERE active),
+              COUNT(*) FILTER (WHERE active AND upload_action = 'new'),
+              COUNT(*) FILTER (WHERE active AND upload_action = 'changed'),
+              COUNT(*) FILTER (WHERE lifecycle_status = '%[8]s'),
+              COUNT(*) FILTER (WHERE lifecycle_status = '%[9]s'),
+              COUNT(price) FILTER (WHERE active),
+              (SUM(price) FILTER (WHERE active))::float8,
+              (percentile_cont(0.5) WITHIN GROUP (ORDER BY price) FILTER (WHERE active))::float8,
+              (AVG(days) FILTER (WHERE active))::float8
+       FROM base
+       GROUP BY GROUPING SETS (%[10]s)
+       ORDER BY 1, 3 DESC, 2
+    `, activeCondition, def.PriceField, days, strings.Join(baseColumns, ",\n                 "), def.Table,
+		dimension, key, lifecycleWithdrawn, lifecycleSold, strings.Join(sets, ", "))
+
+	rows, err := db.Query(query, uploadID)
+	if err != nil {
+		return StatsBucket{}, nil, err
+	}
+	defer rows.Close()
+
+	var summary StatsBucket
+	breakdowns := make(map[string][]StatsBucket, len(dims))
+	for _, d := range dims {
+		breakdowns[d.Name] = make([]StatsBucket, 0)
+	}
+	for rows.Next() {
+		var b StatsBucket
+		var dim string
+		var total, median, avgDays sql.NullFloat64
+		if err := rows.Scan(&dim, &b.Key, &b.Active, &b.New, &b.Changed, &b.Withdrawn, &b.Sold,
+			&b.WithPriceCount, &total, &median, &avgDays); err != nil {
+			return summary, nil, err
+		}
+		b.TotalPrice = nullFloatPtr(total)
+		b.MedianPrice = nullFloatPtr(median)
+		b.AvgDaysOnSale = nullFloatPtr(avgDays)
+		if dim == "" {
+			summary = b
+			continue
+		}
+		breakdowns[dim] = append(breakdowns[dim], b)
+	}
+	return summary, breakdowns, rows.Err()
+}
+
+func nullFloatPtr(nf sql.NullFloat64) *float64 {
+	if nf.Valid {
+		return &nf.Float64
+	}
+	return nil
+}
+
+// Последняя успешная загрузка источника; false — загрузок ещё не было
+func latestUpload(def *SourceDefinition) (Upload, bool, error) {
+	u, err := scanUpload(def, db.QueryRow(`
+       SELECT `+uploadColumns+` FROM uploads
+       WHERE source = $1 AND status = $2
+       ORDER BY uploaded_at DESC, id DESC
+       LIMIT 1
+    `, def.Name, uploadStatusSuccess))
+	if err == sql.ErrNoRows {
+		return u, false, nil
+	}
+	return u, err == nil, err
+}
+
+// GET <prefix>/stats: сводка по источнику и разрезы по виду, подвиду ТС, марке и городу;
+// new и changed — записи, добавленные и изменённые последней загрузкой
+func statsHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		upload, ok, err := latestUpload(def)
+		if err != nil {
+			log.Printf("Failed to fetch latest %s upload: %v", def.Name, err)
+			http.Error(w, "Failed to compute stats", http.StatusInternalServerError)
+			return
+		}
+		summary, breakdowns, err := computeStats(def, upload.ID)
+		if err != nil {
+			log.Printf("Failed to compute %s stats: %v", def.Name, err)
+			http.Error(w, "Failed to compute stats", http.StatusInternalServerError)
+			return
+		}
+
+		response := map[string]interface{}{
+			"source":        def.Name,
+			"summary":       summary,
+			"latest_upload": nil,
+		}
+		if ok {
+			response["latest_upload"] = upload
+		}
+		for name, buckets := range breakdowns {
+			response["by_"+name] = buckets
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Итог сравнения двух загрузок источника по их снимкам
+type UploadComparison struct {
+	Source    string       `json:"source"`
+	From      Upload       `json:"from"`
+	To        Upload       `json:"to"`
+	Added     []string     `json:"added"`
+	Removed   []string     `json:"removed"`
+	Changed   []RecordDiff `json:"changed"`
+	Unchanged int          `json:"unchanged"`
+
+	// Значения строк для выгрузки добавленных и удалённых
+	fromRows map[string]map[string]string
+	toRows   map[string]map[string]string
+}
+
+// Снимок загрузки: нормализованные значения каждой применённой строки файла
+func ensureUploadSnapshotsTable() error {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS upload_snapshots (
+       upload_id INTEGER NOT NULL REFERENCES uploads(id) ON DELETE CASCADE,
+       vin TEXT NOT NULL,
+       "values" JSONB NOT NULL,
+       PRIMARY KEY (upload_id, vin)
+    );
+    `)
+	return err
+}
+
+// Строка файла в снимке загрузки; в предпросмотре снимок не пишется.
+// Повтор VIN в файле заменяет значения: в таблице остаётся последняя строка.
+// Поля, столбцов которых не было в файле, в снимок не попадают.
+func saveSnapshotRow(run *importRun, rec Record) error {
+	if run.uploadID == 0 {
+		return nil
+	}
+	present := make(map[string]string, len(rec.Values))
+	for name, value := range rec.Values {
+		if _, ok := run.cols[name]; ok {
+			present[name] = value
+		}
+	}
+	values, err := json.Marshal(present)
+	if err != nil {
+		return err
+	}
+	_, err = run.tx.Exec(`
+       INSERT INTO upload_snapshots (upload_id, vin, "values") VALUES ($1, $2, $3)
+       ON CONFLICT (upload_id, vin) DO UPDATE SET "values" = EXCLUDED."values"
+    `, run.uploadID, rec.VIN(), values)
+	return err
+}
+
+// Строки снимка успешной загрузки по VIN; false — снимка нет (загрузка старше снимков).
+// Загрузка, в которой не применилась ни одна строка, сравнивается как пустая.
+func loadSnapshot(u Upload) (map[string]map[string]string, bool, error) {
+	rows, err := db.Query(`SELECT vin, "values" FROM upload_snapshots WHERE upload_id = $1`, u.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	snapshot := make(map[string]map[string]string)
+	for rows.Next() {
+		var vin string
+		var data []byte
+		if err := rows.Scan(&vin, &data); err != nil {
+			return nil, false, err
+		}
+		values := make(map[string]string)
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, false, fmt.Errorf("upload %d, vin %s: %w", u.ID, vin, err)
+		}
+		snapshot[vin] = values
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	return snapshot, len(snapshot) > 0 || u.RowsTotal == u.RowsFailed, nil
+}
+
+// Сравнение снимков: добавленные и удалённые VIN, отличия значений по всем полям описания,
+// которые есть в обоих снимках
+func compareSnapshots(def *SourceDefinition, from, to map[string]map[string]string) ([]string, []string, []RecordDiff, int) {
+	added := make([]string, 0)
+	removed := make([]string, 0)
+	changed := make([]RecordDiff, 0)
+	unchanged := 0
+
+	for vin := range to {
+		if _, ok := from[vin]; !ok {
+			added = append(added, vin)
+		}
+	}
+	for vin, old := range from {
+		current, ok := to[vin]
+		if !ok {
+			removed = append(removed, vin)
+			continue
+		}
+		diff := RecordDiff{VIN: vin, Changes: make([]FieldChange, 0)}
+		for _, f := range def.Fields {
+			if f.Name == "vin" {
+				continue
+			}
+			oldValue, inOld := old[f.Name]
+			newValue, inNew := current[f.Name]
+			if !inOld || !inNew {
+				continue
+			}
+			if !fieldValuesEqual(f, oldValue, newValue) {
+				diff.Changes = append(diff.Changes, FieldChange{Field: f.Name, Old: oldValue, New: newValue})
+			}
+		}
+		if len(diff.Changes) == 0 {
+			unchanged++
+			continue
+		}
+		changed = append(changed, diff)
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Slice(changed, func(i, j int) bool { return changed[i].VIN < changed[j].VIN })
+	return added, removed, changed, unchanged
+}
+
+// Загрузка источника по параметру запроса name
+func uploadParam(def *SourceDefinition, r *http.Request, name string) (Upload, int, error) {
+	v := r.URL.Query().Get(name)
+	id, err := strconv.Atoi(v)
+	if err != nil {
+		return Upload{}, http.StatusBadRequest, fmt.Errorf("invalid %s %q: expected upload id", name, v)
+	}
+	u, err := getUpload(def, id)
+	if err == sql.ErrNoRows {
+		return u, http.StatusNotFound, fmt.Errorf("upload %d not found", id)
+	}
+	if err != nil {
+		return u, http.StatusInternalServerError, fmt.Errorf("failed to fetch upload %d", id)
+	}
+	return u, 0, nil
+}
+
+// GET <prefix>/files/compare?from=ID&to=ID[&format=xlsx]: что изменилось между двумя
+// загрузками, даже если между ними были другие
+func compareUploadsHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "xlsx" {
+			http.Error(w, fmt.Sprintf("invalid format %q: expected json or xlsx", format), http.StatusBadRequest)
+			return
+		}
+
+		cmp := UploadComparison{Source: def.Name}
+		snapshots := make([]map[string]map[string]string, 2)
+		for i, name := range []string{"from", "to"} {
+			u, status, err := uploadParam(def, r, name)
+			if err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			// Незавершённая или откаченная загрузка не применила ни одной строки
+			if u.Status != uploadStatusSuccess {
+				http.Error(w, fmt.Sprintf("upload %d has status %s", u.ID, u.Status), http.StatusConflict)
+				return
+			}
+			snapshot, ok, err := loadSnapshot(u)
+			if err != nil {
+				log.Printf("Failed to load snapshot of upload %d: %v", u.ID, err)
+				http.Error(w, "Failed to load upload snapshot", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, fmt.Sprintf("upload %d has no stored snapshot", u.ID), http.StatusConflict)
+				return
+			}
+			snapshots[i] = snapshot
+			if i == 0 {
+				cmp.From = u
+			} else {
+				cmp.To = u
+			}
+		}
+
+		cmp.fromRows, cmp.toRows = snapshots[0], snapshots[1]
+		cmp.Added, cmp.Removed, cmp.Changed, cmp.Unchanged = compareSnapshots(def, cmp.fromRows, cmp.toRows)
+
+		if format == "xlsx" {
+			writeComparisonExcel(w, def, cmp)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cmp)
+	}
+}
+
+// Выгрузка сравнения: листы добавленных, удалённых и изменений по полям
+func writeComparisonExcel(w http.ResponseWriter, def *SourceDefinition, cmp UploadComparison) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	headers := make([]interface{}, 0, len(def.Fields))
+	for _, field := range def.Fields {
+		headers = append(headers, field.Headers[0])
+	}
+	rowValues := func(values map[string]string) []interface{} {
+		row := make([]interface{}, 0, len(def.Fields))
+		for _, field := range def.Fields {
+			row = append(row, exportCellValue(field, values[field.Name]))
+		}
+		return row
+	}
+	writeRows := func(sheet string, rows [][]interface{}) {
+		for i, row := range rows {
+			cell, _ := excelize.CoordinatesToCellName(1, i+1)
+			f.SetSheetRow(sheet, cell, &row)
+		}
+	}
+
+	added := [][]interface{}{headers}
+	for _, vin := range cmp.Added {
+		added = append(added, rowValues(cmp.toRows[vin]))
+	}
+	removed := [][]interface{}{headers}
+	for _, vin := range cmp.Removed {
+		removed = append(removed, rowValues(cmp.fromRows[vin]))
+	}
+	changed := [][]interface{}{{"VIN", "Поле", "Было", "Стало"}}
+	for _, diff := range cmp.Changed {
+		for _, c := range diff.Changes {
+			field, _ := def.field(c.Field)
+			changed = append(changed, []interface{}{diff.VIN, field.Headers[0], exportCellValue(field, c.Old), exportCellValue(field, c.New)})
+		}
+	}
+
+	f.SetSheetName("Sheet1", "Добавлены")
+	writeRows("Добавлены", added)
+	f.NewSheet("Удалены")
+	writeRows("Удалены", removed)
+	f.NewSheet("Изменения")
+	writeRows("Изменения", changed)
+
+	fileName := fmt.Sprintf("%s_compare_%d_%d.xlsx", def.Name, cmp.From.ID, cmp.To.ID)
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+	f.Write(w)
+}
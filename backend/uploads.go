@@ -0,0 +1,378 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Загруженный файл: метаданные, счётчики строк и итог обработки
+type Upload struct {
+	ID            int       `json:"id"`
+	Source        string    `json:"source"`
+	FileName      string    `json:"file_name"`
+	SHA256        string    `json:"sha256"`
+	SizeBytes     int64     `json:"size_bytes"`
+	UploadedBy    string    `json:"uploaded_by"`
+	UploadedAt    time.Time `json:"uploaded_at"`
+	RowsTotal     int       `json:"rows_total"`
+	RowsNew       int       `json:"rows_new"`
+	RowsChanged   int       `json:"rows_changed"`
+	RowsDeleted   int       `json:"rows_deleted"`
+	RowsFailed    int       `json:"rows_failed"`
+	RowsWithdrawn int       `json:"rows_withdrawn"`
+	RowsRelisted  int       `json:"rows_relisted"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	RecordsURL    string    `json:"records_url"`
+}
+
+// VIN, затронутый загрузкой, и что с ним произошло: new, changed, deleted, withdrawn, relisted
+type UploadRecord struct {
+	VIN    string `json:"vin"`
+	Action string `json:"action"`
+}
+
+const (
+	uploadStatusProcessing = "processing"
+	uploadStatusSuccess    = "success"
+	uploadStatusFailed     = "failed"
+)
+
+func ensureUploadTables() error {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS uploads (
+       id SERIAL PRIMARY KEY,
+       source TEXT NOT NULL,
+       file_name TEXT NOT NULL,
+       sha256 TEXT NOT NULL,
+       size_bytes BIGINT NOT NULL,
+       uploaded_by TEXT,
+       uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+       rows_total INTEGER DEFAULT 0,
+       rows_new INTEGER DEFAULT 0,
+       rows_changed INTEGER DEFAULT 0,
+       rows_deleted INTEGER DEFAULT 0,
+       status TEXT NOT NULL,
+       error TEXT
+    );
+    CREATE INDEX IF NOT EXISTS uploads_source_idx ON uploads (source, uploaded_at DESC);
+    ALTER TABLE uploads ADD COLUMN IF NOT EXISTS result JSONB;
+    ALTER TABLE uploads ADD COLUMN IF NOT EXISTS rows_failed INTEGER DEFAULT 0;
+    ALTER TABLE uploads ADD COLUMN IF NOT EXISTS rows_withdrawn INTEGER DEFAULT 0;
+    ALTER TABLE uploads ADD COLUMN IF NOT EXISTS rows_relisted INTEGER DEFAULT 0;
+    CREATE INDEX IF NOT EXISTS uploads_sha256_idx ON uploads (source, sha256);
+
+    CREATE TABLE IF NOT EXISTS upload_records (
+       upload_id INTEGER NOT NULL REFERENCES uploads(id) ON DELETE CASCADE,
+       vin TEXT NOT NULL,
+       action TEXT NOT NULL,
+       PRIMARY KEY (upload_id, vin)
+    );
+    CREATE INDEX IF NOT EXISTS upload_records_vin_idx ON upload_records (vin);
+    `)
+	return err
+}
+
+// Создание строки загрузки в начале импорта, чтобы её id попадал в историю цен и изменений
+func createUpload(tx dbExecutor, def *SourceDefinition, upload *Upload) error {
+	upload.Source = def.Name
+	if upload.Status == "" {
+		upload.Status = uploadStatusProcessing
+	}
+	err := tx.QueryRow(`
+       INSERT INTO uploads (source, file_name, sha256, size_bytes, uploaded_by, status)
+       VALUES ($1,$2,$3,$4,$5,$6)
+       RETURNING id, uploaded_at
+    `,
+		def.Name,
+		upload.FileName,
+		upload.SHA256,
+		upload.SizeBytes,
+		upload.UploadedBy,
+		upload.Status,
+	).Scan(&upload.ID, &upload.UploadedAt)
+	if err != nil {
+		return err
+	}
+	upload.RecordsURL = uploadRecordsURL(def, upload.ID)
+	return nil
+}
+
+// Итог загрузки: счётчики, статус, результат и связи с затронутыми записями
+func finishUpload(tx dbExecutor, upload *Upload, result *importResult) error {
+	var outcome interface{}
+	if result != nil {
+		encoded, err := json.Marshal(map[string]interface{}{
+			"records":   result.Records,
+			"deleted":   result.Deleted,
+			"withdrawn": result.Withdrawn,
+			"relisted":  result.Relisted,
+			"errors":    result.Errors,
+		})
+		if err != nil {
+			return err
+		}
+		outcome = string(encoded)
+	}
+
+	_, err := tx.Exec(`
+       UPDATE uploads SET
+          rows_total     = $1,
+          rows_new       = $2,
+          rows_changed   = $3,
+          rows_deleted   = $4,
+          rows_failed    = $5,
+          rows_withdrawn = $6,
+          rows_relisted  = $7,
+          status         = $8,
+          error          = $9,
+          result         = $10
+       WHERE id = $11
+    `,
+		upload.RowsTotal,
+		upload.RowsNew,
+		upload.RowsChanged,
+		upload.RowsDeleted,
+		upload.RowsFailed,
+		upload.RowsWithdrawn,
+		upload.RowsRelisted,
+		upload.Status,
+		upload.Error,
+		outcome,
+		upload.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if result != nil && upload.Status == uploadStatusSuccess {
+		stmt, err := tx.Prepare(`
+           INSERT INTO upload_records (upload_id, vin, action) VALUES ($1, $2, $3)
+           ON CONFLICT (upload_id, vin) DO UPDATE SET action = EXCLUDED.action
+        `)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, rec := range result.Records {
+			action := "changed"
+			if rec.IsNew {
+				action = "new"
+			}
+			if _, err := stmt.Exec(upload.ID, rec.VIN(), action); err != nil {
+				return err
+			}
+		}
+		for _, vin := range result.Deleted {
+			if _, err := stmt.Exec(upload.ID, vin, "deleted"); err != nil {
+				return err
+			}
+		}
+		for _, vin := range result.Withdrawn {
+			if _, err := stmt.Exec(upload.ID, vin, "withdrawn"); err != nil {
+				return err
+			}
+		}
+		for _, vin := range result.Relisted {
+			if _, err := stmt.Exec(upload.ID, vin, "relisted"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Запись завершённой загрузки целиком (для неудачных загрузок вне транзакции импорта)
+func saveUpload(q dbExecutor, def *SourceDefinition, upload *Upload, result *importResult) error {
+	if err := createUpload(q, def, upload); err != nil {
+		return err
+	}
+	return finishUpload(q, upload, result)
+}
+
+// Счётчики строк загрузки по итогу импорта
+func (u *Upload) countResult(result *importResult) {
+	u.RowsTotal = result.RowsTotal
+	u.RowsDeleted = len(result.Deleted)
+	u.RowsFailed = len(result.Errors)
+	u.RowsWithdrawn = len(result.Withdrawn)
+	u.RowsRelisted = len(result.Relisted)
+	u.RowsNew, u.RowsChanged = 0, 0
+	for _, rec := range result.Records {
+		if rec.IsNew {
+			u.RowsNew++
+		} else {
+			u.RowsChanged++
+		}
+	}
+}
+
+const uploadColumns = `id, source, file_name, sha256, size_bytes, COALESCE(uploaded_by, ''), uploaded_at,
+              rows_total, rows_new, rows_changed, rows_deleted, COALESCE(rows_failed, 0), COALESCE(rows_withdrawn, 0),
+              COALESCE(rows_relisted, 0), status, COALESCE(error, '')`
+
+// extra — столбцы, выбранные после uploadColumns
+func scanUpload(def *SourceDefinition, row rowScanner, extra ...interface{}) (Upload, error) {
+	var u Upload
+	dest := []interface{}{&u.ID, &u.Source, &u.FileName, &u.SHA256, &u.SizeBytes, &u.UploadedBy, &u.UploadedAt,
+		&u.RowsTotal, &u.RowsNew, &u.RowsChanged, &u.RowsDeleted, &u.RowsFailed, &u.RowsWithdrawn, &u.RowsRelisted, &u.Status, &u.Error}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return u, err
+	}
+	u.RecordsURL = uploadRecordsURL(def, u.ID)
+	return u, nil
+}
+
+func getUpload(def *SourceDefinition, id int) (Upload, error) {
+	return scanUpload(def, db.QueryRow(`SELECT `+uploadColumns+` FROM uploads WHERE source = $1 AND id = $2`, def.Name, id))
+}
+
+// Последняя успешная загрузка файла с тем же содержимым и её сохранённый результат
+func findUploadByHash(def *SourceDefinition, sha string) (Upload, json.RawMessage, bool, error) {
+	var outcome []byte
+	row := db.QueryRow(`
+       SELECT `+uploadColumns+`, COALESCE(result, '{}')
+       FROM uploads
+       WHERE source = $1 AND sha256 = $2 AND status = $3
+         AND uploaded_at > COALESCE((
+            SELECT MAX(occurred_at) FROM record_events WHERE source = $1 AND reason = $4
+         ), '-infinity')
+       ORDER BY uploaded_at DESC, id DESC
+       LIMIT 1
+    `, def.Name, sha, uploadStatusSuccess, eventReasonCleared)
+
+	u, err := scanUpload(def, row, &outcome)
+	if err == sql.ErrNoRows {
+		return u, nil, false, nil
+	}
+	if err != nil {
+		return u, nil, false, err
+	}
+	return u, outcome, true, nil
+}
+
+func uploadRecordsURL(def *SourceDefinition, id int) string {
+	return fmt.Sprintf("%s/files/%d", def.RoutePrefix, id)
+}
+
+// Список загрузок источника, новые сверху
+func filesHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, limit, err := parsePagination(r, 50, 500)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var total int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM uploads WHERE source = $1`, def.Name).Scan(&total); err != nil {
+			http.Error(w, "Failed to fetch uploads", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := db.Query(`
+           SELECT `+uploadColumns+`
+           FROM uploads WHERE source = $1
+           ORDER BY uploaded_at DESC, id DESC
+           LIMIT $2 OFFSET $3
+        `, def.Name, limit, (page-1)*limit)
+		if err != nil {
+			http.Error(w, "Failed to fetch uploads", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		items := make([]Upload, 0)
+		for rows.Next() {
+			u, err := scanUpload(def, rows)
+			if err != nil {
+				http.Error(w, "Failed to fetch uploads", http.StatusInternalServerError)
+				return
+			}
+			items = append(items, u)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"items": items,
+			"total": total,
+			"page":  page,
+			"limit": limit,
+		})
+	}
+}
+
+// Одна загрузка и VIN, которые она затронула
+func fileDetailHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid upload id", http.StatusBadRequest)
+			return
+		}
+
+		upload, err := getUpload(def, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch upload", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := db.Query(`SELECT vin, action FROM upload_records WHERE upload_id = $1 ORDER BY action, vin`, id)
+		if err != nil {
+			http.Error(w, "Failed to fetch upload records", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		touched := make([]UploadRecord, 0)
+		var vins []string
+		for rows.Next() {
+			var ur UploadRecord
+			if err := rows.Scan(&ur.VIN, &ur.Action); err != nil {
+				http.Error(w, "Failed to fetch upload records", http.StatusInternalServerError)
+				return
+			}
+			touched = append(touched, ur)
+			vins = append(vins, ur.VIN)
+		}
+
+		// Текущее состояние затронутых записей, включая снятые с продажи
+		current := make([]Record, 0)
+		if len(vins) > 0 {
+			recRows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE vin = ANY($1)", selectColumns(def), def.Table), pq.Array(vins))
+			if err != nil {
+				http.Error(w, "Failed to fetch records", http.StatusInternalServerError)
+				return
+			}
+			defer recRows.Close()
+			for recRows.Next() {
+				rec, err := scanRecord(def, recRows)
+				if err != nil {
+					http.Error(w, "Failed to fetch records", http.StatusInternalServerError)
+					return
+				}
+				current = append(current, rec)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"upload":          upload,
+			"touched_records": touched,
+			"records":         current,
+		})
+	}
+}
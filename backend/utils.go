@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"fmt"
-
-	"github.com/xuri/excelize/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Преобразование sql.NullString в строку
@@ -15,17 +19,90 @@ func nullStringToString(ns sql.NullString) string {
 	return ""
 }
 
-// Получение значения ячейки по букве столбца и номеру строки (1-based)
-func getCellValueByColumn(f *excelize.File, sheetName string, colLetter string, rowNum int) string {
-	cellName := fmt.Sprintf("%s%d", colLetter, rowNum)
-	value, err := f.GetCellValue(sheetName, cellName)
-	if err != nil {
-		return ""
+// Пустая строка передаётся в запрос как NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
 	}
-	return value
+	return s
+}
+
+func nullTimePtr(nt sql.NullTime) *time.Time {
+	if nt.Valid {
+		return &nt.Time
+	}
+	return nil
 }
 
 // Поиск фотографий по VIN (заглушка, возвращает пустой массив)
 func searchPhotos(vin string) []string {
 	return []string{}
 }
+
+// Пользователь, выполняющий запрос: заголовок X-User или поле формы uploaded_by
+func requestUser(r *http.Request) string {
+	if user := strings.TrimSpace(r.Header.Get("X-User")); user != "" {
+		return user
+	}
+	return strings.TrimSpace(r.FormValue("uploaded_by"))
+}
+
+// Проверка токена администратора из заголовка X-Admin-Token.
+// Без ADMIN_TOKEN в окружении административные операции недоступны.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		http.Error(w, "Admin operations are disabled: ADMIN_TOKEN is not set", http.StatusForbidden)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(token)) != 1 {
+		http.Error(w, "Admin token required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Параметры постраничного вывода page (с 1) и limit
+func parsePagination(r *http.Request, defaultLimit, maxLimit int) (int, int, error) {
+	page, limit := 1, defaultLimit
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid page %q", v)
+		}
+		page = n
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			return 0, 0, fmt.Errorf("invalid limit %q: expected 1..%d", v, maxLimit)
+		}
+		limit = n
+	}
+	return page, limit, nil
+}
+
+// Логический флаг из строки запроса или формы: 1, true, yes, on
+func formFlag(r *http.Request, name string) bool {
+	switch strings.ToLower(strings.TrimSpace(r.FormValue(name))) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// Дата из параметра запроса: 2006-01-02 или RFC3339.
+// Для верхней границы дата без времени означает конец этого дня.
+func parseDateParam(value string, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or RFC3339, got %q", value)
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Всё о VIN у одного лизингодателя
+type VINDetail struct {
+	Source       string           `json:"source"`
+	Record       Record           `json:"record"`
+	PriceHistory []PricePoint     `json:"price_history"`
+	ChangeLog    []ChangeLogEntry `json:"change_log"`
+	Events       []RecordEvent    `json:"events"`
+	Uploads      []VINUpload      `json:"uploads"`
+	Photos       []string         `json:"photos"`
+}
+
+// Загрузка, затронувшая VIN, и что с ним произошло
+type VINUpload struct {
+	Upload
+	Action string `json:"action"`
+}
+
+// Карточка VIN в источнике; false — записи с таким VIN нет
+func fetchVINDetail(def *SourceDefinition, vin string) (VINDetail, bool, error) {
+	detail := VINDetail{Source: def.Name}
+	rec, ok, err := getRecordByVIN(db, def, vin)
+	if err != nil || !ok {
+		return detail, false, err
+	}
+	detail.Record = rec
+
+	if detail.PriceHistory, err = fetchPriceHistory(def, vin); err != nil {
+		return detail, false, err
+	}
+	if detail.ChangeLog, err = fetchVINChangeLog(def, vin); err != nil {
+		return detail, false, err
+	}
+	if detail.Events, err = fetchVINEvents(def, vin); err != nil {
+		return detail, false, err
+	}
+	if detail.Uploads, err = fetchVINUploads(def, vin); err != nil {
+		return detail, false, err
+	}
+
+	detail.Photos = rec.Photos
+	if len(detail.Photos) == 0 {
+		detail.Photos = searchPhotos(vin)
+	}
+	return detail, true, nil
+}
+
+func fetchVINChangeLog(def *SourceDefinition, vin string) ([]ChangeLogEntry, error) {
+	rows, err := db.Query(`
+       SELECT id, source, vin, field, COALESCE(old_value, ''), COALESCE(new_value, ''), upload_id, changed_at
+       FROM change_log WHERE source = $1 AND vin = $2
+       ORDER BY changed_at DESC, id DESC
+    `, def.Name, vin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]ChangeLogEntry, 0)
+	for rows.Next() {
+		var e ChangeLogEntry
+		var uploadID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.Source, &e.VIN, &e.Field, &e.OldValue, &e.NewValue, &uploadID, &e.ChangedAt); err != nil {
+			return nil, err
+		}
+		if uploadID.Valid {
+			id := int(uploadID.Int64)
+			e.UploadID = &id
+		}
+		items = append(items, e)
+	}
+	return items, rows.Err()
+}
+
+func fetchVINEvents(def *SourceDefinition, vin string) ([]RecordEvent, error) {
+	rows, err := db.Query(`
+       SELECT id, source, vin, status, COALESCE(previous_status, ''), reason, upload_id, occurred_at
+       FROM record_events WHERE source = $1 AND vin = $2
+       ORDER BY occurred_at DESC, id DESC
+    `, def.Name, vin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]RecordEvent, 0)
+	for rows.Next() {
+		var e RecordEvent
+		var uploadID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.Source, &e.VIN, &e.Status, &e.PreviousStatus, &e.Reason, &uploadID, &e.OccurredAt); err != nil {
+			return nil, err
+		}
+		if uploadID.Valid {
+			id := int(uploadID.Int64)
+			e.UploadID = &id
+		}
+		items = append(items, e)
+	}
+	return items, rows.Err()
+}
+
+func fetchVINUploads(def *SourceDefinition, vin string) ([]VINUpload, error) {
+	rows, err := db.Query(`
+       SELECT `+uploadColumns+`, ur.action
+       FROM upload_records ur
+       JOIN uploads ON uploads.id = ur.upload_id
+       WHERE uploads.source = $1 AND ur.vin = $2
+       ORDER BY uploaded_at DESC, id DESC
+    `, def.Name, vin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]VINUpload, 0)
+	for rows.Next() {
+		var item VINUpload
+		u, err := scanUpload(def, rows, &item.Action)
+		if err != nil {
+			return nil, err
+		}
+		item.Upload = u
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// GET <prefix>/records/{vin}: карточка машины в источнике
+func recordDetailHandler(def *SourceDefinition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vin := lookupVIN(mux.Vars(r)["vin"])
+		detail, ok, err := fetchVINDetail(def, vin)
+		if err != nil {
+			log.Printf("Failed to fetch %s record %s: %v", def.Name, vin, err)
+			http.Error(w, "Failed to fetch record", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Record not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(detail)
+	}
+}
+
+// GET /api/vehicles/{vin}: карточки машины у всех лизингодателей, где она есть
+func vehicleDetailHandler(w http.ResponseWriter, r *http.Request) {
+	vin := lookupVIN(mux.Vars(r)["vin"])
+	listings := make([]VINDetail, 0)
+	for _, def := range sources {
+		detail, ok, err := fetchVINDetail(def, vin)
+		if err != nil {
+			log.Printf("Failed to fetch %s record %s: %v", def.Name, vin, err)
+			http.Error(w, "Failed to fetch vehicle", http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			listings = append(listings, detail)
+		}
+	}
+	if len(listings) == 0 {
+		http.Error(w, "Vehicle not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"vin":     vin,
+		"decoded": vinDecoder.decode(vin),
+		"sources": listings,
+	})
+}
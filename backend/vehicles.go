@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Машина в общем каталоге: один VIN и его объявления у всех лизингодателей
+type Vehicle struct {
+	VIN         string           `json:"vin"`
+	Brand       string           `json:"brand"`
+	Model       string           `json:"model"`
+	VehicleType string           `json:"vehicle_type"`
+	Year        *int             `json:"year"`
+	MinPrice    *float64         `json:"min_price"`
+	MaxPrice    *float64         `json:"max_price"`
+	FirstSeen   time.Time        `json:"first_seen"`
+	Listings    []VehicleListing `json:"listings"`
+}
+
+// Объявление машины у одного лизингодателя
+type VehicleListing struct {
+	Source      string    `json:"source"`
+	RecordID    int       `json:"record_id"`
+	Brand       string    `json:"brand"`
+	Model       string    `json:"model"`
+	VehicleType string    `json:"vehicle_type"`
+	City        string    `json:"city"`
+	Region      string    `json:"region"`
+	Year        *int      `json:"year"`
+	Price       *float64  `json:"price"`
+	Lifecycle   string    `json:"lifecycle_status"`
+	ListedSince time.Time `json:"listed_since"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	HistoryURL  string    `json:"price_history_url"`
+}
+
+// Сортировки каталога: ключ запроса → выражение по сгруппированным VIN
+var vehicleSorts = map[string]string{
+	"price":      "min_price",
+	"first_seen": "first_seen",
+	"listings":   "listings",
+	"year":       "year",
+	"vin":        "vin",
+	"brand":      "brand",
+}
+
+// Столбец источника или NULL, если у источника нет такого поля
+func catalogColumn(field string) string {
+	if field == "" {
+		return "NULL::text"
+	}
+	return field + "::text"
+}
+
+// Объявления всех источников в общем виде: UNION ALL по таблицам.
+// Марка берётся из словаря псевдонимов, затем из расшифровки VIN, затем из файла лизингодателя;
+// город — из справочника населённых пунктов, затем из файла.
+func vehicleListingsSQL(defs []*SourceDefinition) string {
+	parts := make([]string, 0, len(defs))
+	for _, def := range defs {
+		year := "NULL::integer"
+		if def.YearField != "" {
+			year = def.YearField
+		}
+		parts = append(parts, fmt.Sprintf(`
+          SELECT '%s'::text AS source, id, vin,
+                 COALESCE(canonical_make, NULLIF(vin_brand, ''), %s) AS brand,
+                 %s AS brand_raw,
+                 COALESCE(canonical_model, %s) AS model,
+                 %s AS vehicle_type,
+                 COALESCE(geo_city, %s) AS city,
+                 geo_region AS region,
+                 %s AS year,
+                 %s::numeric AS price,
+                 lifecycle_status, created_at AS listed_since, updated_at
+          FROM %s`,
+			def.Name,
+			catalogColumn(def.BrandField),
+			catalogColumn(def.BrandField),
+			catalogColumn(def.ModelField),
+			catalogColumn(def.VehicleTypeField),
+			catalogColumn(def.CityField),
+			year,
+			def.PriceField,
+			def.Table,
+		))
+	}
+	return strings.Join(parts, "\n          UNION ALL")
+}
+
+// GET /api/vehicles: машины всех лизингодателей, сгруппированные по VIN.
+// Фильтры применяются к объявлениям: VIN попадает в выдачу, если подходит хотя бы одно.
+func vehiclesHandler(w http.ResponseWriter, r *http.Request) {
+	page, limit, err := parsePagination(r, 50, 500)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "$?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	defs := sources
+	if v := q.Get("source"); v != "" {
+		defs = nil
+		for _, name := range strings.Split(v, ",") {
+			def, ok := sourceByName(name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown source %q", name), http.StatusBadRequest)
+				return
+			}
+			defs = append(defs, def)
+		}
+	}
+
+	if q.Get("lifecycle") != "all" {
+		conditions = append(conditions, activeCondition)
+	}
+	if v := q.Get("vin"); v != "" {
+		add("vin LIKE '%' || $?", strings.ToUpper(strings.TrimSpace(v)))
+	}
+	for _, text := range []struct{ param, condition string }{
+		{"brand", "(brand ILIKE $? OR brand_raw ILIKE '%' || $? || '%')"},
+		{"model", "model ILIKE '%' || $? || '%'"},
+		{"vehicle_type", "vehicle_type ILIKE '%' || $? || '%'"},
+		{"city", "city ILIKE '%' || $? || '%'"},
+		{"region", "region ILIKE '%' || $? || '%'"},
+	} {
+		if v := q.Get(text.param); v != "" {
+			add(text.condition, v)
+		}
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"price_min", "price >= $?"},
+		{"price_max", "price <= $?"},
+		{"year_min", "year >= $?"},
+		{"year_max", "year <= $?"},
+	} {
+		v := q.Get(bound.param)
+		if v == "" {
+			continue
+		}
+		n, err := parseNumber(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s: %v", bound.param, err), http.StatusBadRequest)
+			return
+		}
+		add(bound.condition, n)
+	}
+
+	sortKey := q.Get("sort")
+	desc := strings.HasPrefix(sortKey, "-")
+	sortKey = strings.TrimPrefix(sortKey, "-")
+	if sortKey == "" {
+		sortKey = "first_seen"
+		desc = true
+	}
+	orderBy, ok := vehicleSorts[sortKey]
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid sort %q", q.Get("sort")), http.StatusBadRequest)
+		return
+	}
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+
+	if len(defs) == 0 {
+		writeVehicles(w, []Vehicle{}, 0, page, limit)
+		return
+	}
+
+	where := "TRUE"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+	listings := vehicleListingsSQL(defs)
+
+	filtered := fmt.Sprintf(`
+       WITH listings AS (%s
+       ),
+       filtered AS (
+          SELECT * FROM listings WHERE %s
+       )`, listings, where)
+
+	var total int
+	if err := db.QueryRow(filtered+" SELECT COUNT(DISTINCT vin) FROM filtered", args...).Scan(&total); err != nil {
+		log.Printf("Failed to count vehicles: %v", err)
+		http.Error(w, "Failed to fetch vehicles", http.StatusInternalServerError)
+		return
+	}
+
+	query := fmt.Sprintf(`%s,
+       grouped AS (
+          SELECT vin,
+                 MAX(brand) AS brand,
+                 MAX(model) AS model,
+                 MAX(vehicle_type) AS vehicle_type,
+                 MAX(year) AS year,
+                 MIN(price) AS min_price,
+                 MAX(price) AS max_price,
+                 MIN(listed_since) AS first_seen,
+                 COUNT(*) AS listings
+          FROM filtered GROUP BY vin
+       )
+       SELECT vin, COALESCE(brand, ''), COALESCE(model, ''), COALESCE(vehicle_type, ''),
+              year, min_price::float8, max_price::float8, first_seen
+       FROM grouped
+       ORDER BY %s %s NULLS LAST, vin
+       LIMIT $%d OFFSET $%d
+    `, filtered, orderBy, direction, len(args)+1, len(args)+2)
+
+	rows, err := db.Query(query, append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		log.Printf("Failed to fetch vehicles: %v", err)
+		http.Error(w, "Failed to fetch vehicles", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	vehicles := make([]Vehicle, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		var v Vehicle
+		if err := rows.Scan(&v.VIN, &v.Brand, &v.Model, &v.VehicleType, &v.Year, &v.MinPrice, &v.MaxPrice, &v.FirstSeen); err != nil {
+			http.Error(w, "Failed to fetch vehicles", http.StatusInternalServerError)
+			return
+		}
+		v.Listings = make([]VehicleListing, 0)
+		index[v.VIN] = len(vehicles)
+		vehicles = append(vehicles, v)
+	}
+	rows.Close()
+
+	if len(vehicles) > 0 {
+		vins := make([]string, 0, len(vehicles))
+		for _, v := range vehicles {
+			vins = append(vins, v.VIN)
+		}
+		if err := attachListings(vehicles, index, listings, vins, q.Get("lifecycle") == "all"); err != nil {
+			log.Printf("Failed to fetch vehicle listings: %v", err)
+			http.Error(w, "Failed to fetch vehicles", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeVehicles(w, vehicles, total, page, limit)
+}
+
+// Все объявления выбранных VIN, а не только подошедшие под фильтр
+func attachListings(vehicles []Vehicle, index map[string]int, listings string, vins []string, all bool) error {
+	where := "vin = ANY($1)"
+	if !all {
+		where += " AND " + activeCondition
+	}
+	rows, err := db.Query(fmt.Sprintf(`
+       WITH listings AS (%s
+       )
+       SELECT source, id, vin, COALESCE(brand_raw, ''), COALESCE(model, ''), COALESCE(vehicle_type, ''), COALESCE(city, ''), COALESCE(region, ''),
+              year, price::float8, lifecycle_status, listed_since, updated_at
+       FROM listings WHERE %s
+       ORDER BY price NULLS LAST, source
+    `, listings, where), pq.Array(vins))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l VehicleListing
+		var vin string
+		if err := rows.Scan(&l.Source, &l.RecordID, &vin, &l.Brand, &l.Model, &l.VehicleType, &l.City, &l.Region,
+			&l.Year, &l.Price, &l.Lifecycle, &l.ListedSince, &l.UpdatedAt); err != nil {
+			return err
+		}
+		if def, ok := sourceByName(l.Source); ok {
+			l.HistoryURL = def.RoutePrefix + "/price-history/" + vin
+		}
+		i := index[vin]
+		vehicles[i].Listings = append(vehicles[i].Listings, l)
+	}
+	return rows.Err()
+}
+
+func writeVehicles(w http.ResponseWriter, vehicles []Vehicle, total, page, limit int) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": vehicles,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// Кириллические буквы, которые в файлах встречаются вместо латинских
+var vinHomoglyphs = strings.NewReplacer(
+	"А", "A", "В", "B", "Е", "E", "К", "K", "М", "M", "Н", "H",
+	"О", "O", "Р", "P", "С", "C", "Т", "T", "Х", "X",
+)
+
+// Вес позиции и значения букв для контрольной цифры ISO 3779
+var (
+	vinWeights = [17]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+	vinLetters = map[rune]int{
+		'A': 1, 'B': 2, 'C': 3, 'D': 4, 'E': 5, 'F': 6, 'G': 7, 'H': 8,
+		'J': 1, 'K': 2, 'L': 3, 'M': 4, 'N': 5, 'P': 7, 'R': 9,
+		'S': 2, 'T': 3, 'U': 4, 'V': 5, 'W': 6, 'X': 7, 'Y': 8, 'Z': 9,
+	}
+)
+
+// Приведение VIN к каноническому виду: без пробелов, в верхнем регистре,
+// кириллические двойники заменены латиницей. Ошибка — VIN недопустим или подозрителен.
+func normalizeVIN(raw string) (string, error) {
+	vin := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, raw)
+	vin = vinHomoglyphs.Replace(strings.ToUpper(vin))
+
+	if n := len([]rune(vin)); n != 17 {
+		return vin, fmt.Errorf("invalid VIN %q: length %d, expected 17", raw, n)
+	}
+	for i, r := range []rune(vin) {
+		switch {
+		case r == 'I' || r == 'O' || r == 'Q':
+			return vin, fmt.Errorf("invalid VIN %q: letter %c at position %d is not allowed", raw, r, i+1)
+		case r >= '0' && r <= '9', r >= 'A' && r <= 'Z':
+		default:
+			return vin, fmt.Errorf("invalid VIN %q: character %q at position %d", raw, r, i+1)
+		}
+	}
+	if strings.Count(vin, vin[:1]) == len(vin) {
+		return vin, fmt.Errorf("suspicious VIN %q: all characters are the same", raw)
+	}
+
+	// Контрольная цифра обязательна для машин Северной Америки (WMI на 1–5)
+	if vin[0] >= '1' && vin[0] <= '5' {
+		if expected := vinCheckDigit(vin); vin[8] != expected {
+			return vin, fmt.Errorf("suspicious VIN %q: check digit %c, expected %c", raw, vin[8], expected)
+		}
+	}
+	return vin, nil
+}
+
+func vinCheckDigit(vin string) byte {
+	sum := 0
+	for i, r := range vin {
+		value, ok := vinLetters[r]
+		if !ok {
+			value = int(r - '0')
+		}
+		sum += value * vinWeights[i]
+	}
+	if rem := sum % 11; rem < 10 {
+		return byte('0' + rem)
+	}
+	return 'X'
+}
+
+// VIN из запроса: нормализованный, если он допустим, иначе как передан
+func lookupVIN(raw string) string {
+	vin, err := normalizeVIN(raw)
+	if err != nil {
+		return strings.TrimSpace(raw)
+	}
+	return vin
+}
+
+// Приведение VIN, сохранённых до нормализации, вместе с историей по ним.
+// Запись не трогается, если VIN недопустим или нормализованный VIN уже занят.
+func normalizeStoredVINs(def *SourceDefinition) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT vin FROM %s", def.Table))
+	if err != nil {
+		return err
+	}
+	var stored []string
+	for rows.Next() {
+		var vin string
+		if err := rows.Scan(&vin); err != nil {
+			rows.Close()
+			return err
+		}
+		stored = append(stored, vin)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	taken := make(map[string]bool, len(stored))
+	for _, vin := range stored {
+		taken[vin] = true
+	}
+
+	for _, vin := range stored {
+		normalized, err := normalizeVIN(vin)
+		if err != nil || normalized == vin {
+			continue
+		}
+		if taken[normalized] {
+			log.Printf("%s: VIN %q normalizes to existing %q, left as is", def.Table, vin, normalized)
+			continue
+		}
+		if err := renameVIN(def, vin, normalized); err != nil {
+			return fmt.Errorf("rename VIN %q: %w", vin, err)
+		}
+		taken[normalized] = true
+	}
+	return nil
+}
+
+func renameVIN(def *SourceDefinition, from, to string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET vin = $1 WHERE vin = $2", def.Table), to, from); err != nil {
+		return err
+	}
+	for _, table := range []string{"price_history", "change_log", "record_events"} {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET vin = $1 WHERE source = $2 AND vin = $3", table), to, def.Name, from); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`
+       UPDATE upload_records ur SET vin = $1
+       FROM uploads u
+       WHERE u.id = ur.upload_id AND u.source = $2 AND ur.vin = $3
+         AND NOT EXISTS (SELECT 1 FROM upload_records o WHERE o.upload_id = ur.upload_id AND o.vin = $1)
+    `, to, def.Name, from)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
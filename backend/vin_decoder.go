@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed vindata/wmi.yaml
+var embeddedWMITable []byte
+
+// Диапазон первых двух символов VIN, закреплённый за страной
+type wmiCountryRange struct {
+	From    string `yaml:"from"`
+	To      string `yaml:"to"`
+	Country string `yaml:"country"`
+}
+
+// Производитель по WMI; Brand и Aliases — для сверки с маркой лизингодателя
+type wmiManufacturer struct {
+	WMI          string   `yaml:"wmi"`
+	Manufacturer string   `yaml:"manufacturer"`
+	Brand        string   `yaml:"brand"`
+	Aliases      []string `yaml:"aliases"`
+}
+
+type wmiTable struct {
+	Countries     []wmiCountryRange `yaml:"countries"`
+	Manufacturers []wmiManufacturer `yaml:"manufacturers"`
+}
+
+// Итог расшифровки VIN; пустые поля — расшифровать не удалось
+type VINDecode struct {
+	Manufacturer string `json:"manufacturer"`
+	Brand        string `json:"brand"`
+	Country      string `json:"country"`
+	ModelYear    int    `json:"model_year"`
+}
+
+// Порядок символов в диапазонах стран
+const vinRangeOrder = "ABCDEFGHJKLMNPRSTUVWXYZ1234567890"
+
+// Символ 10-й позиции VIN и первый год его 30-летнего цикла
+const vinYearCodes = "ABCDEFGHJKLMNPRSTVWXY123456789"
+
+// Таблица расшифровки, загруженная при старте
+var vinDecoder = mustParseWMITable(embeddedWMITable)
+
+func mustParseWMITable(data []byte) *wmiTable {
+	table, err := parseWMITable(data)
+	if err != nil {
+		panic(fmt.Sprintf("embedded WMI table: %v", err))
+	}
+	return table
+}
+
+func parseWMITable(data []byte) (*wmiTable, error) {
+	var table wmiTable
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&table); err != nil {
+		return nil, err
+	}
+	for i, c := range table.Countries {
+		if len(c.From) != len(c.To) || len(c.From) < 1 || len(c.From) > 2 || c.Country == "" {
+			return nil, fmt.Errorf("countries[%d]: from and to must both have 1 or 2 characters, country is required", i)
+		}
+	}
+	for i, m := range table.Manufacturers {
+		if len(m.WMI) < 2 || len(m.WMI) > 3 || m.Manufacturer == "" {
+			return nil, fmt.Errorf("manufacturers[%d]: wmi must have 2 or 3 characters, manufacturer is required", i)
+		}
+	}
+	return &table, nil
+}
+
+// Дополнение встроенной таблицы файлом path (YAML или JSON); пустой путь — без изменений
+func loadVINDecoder(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	override, err := parseWMITable(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	merged := &wmiTable{
+		Countries:     append(append([]wmiCountryRange{}, override.Countries...), vinDecoder.Countries...),
+		Manufacturers: append([]wmiManufacturer{}, override.Manufacturers...),
+	}
+	overridden := make(map[string]bool, len(override.Manufacturers))
+	for _, m := range override.Manufacturers {
+		overridden[m.WMI] = true
+	}
+	for _, m := range vinDecoder.Manufacturers {
+		if !overridden[m.WMI] {
+			merged.Manufacturers = append(merged.Manufacturers, m)
+		}
+	}
+	vinDecoder = merged
+	return nil
+}
+
+// Расшифровка нормализованного VIN
+func (t *wmiTable) decode(vin string) VINDecode {
+	var d VINDecode
+	if len(vin) != 17 {
+		return d
+	}
+
+	// Сначала полный WMI, затем производители, заданные двумя символами
+	for _, size := range []int{3, 2} {
+		for _, m := range t.Manufacturers {
+			if len(m.WMI) == size && strings.HasPrefix(vin, m.WMI) {
+				d.Manufacturer, d.Brand = m.Manufacturer, m.Brand
+				break
+			}
+		}
+		if d.Manufacturer != "" {
+			break
+		}
+	}
+
+	for _, c := range t.Countries {
+		if inVINRange(vin, c.From, c.To) {
+			d.Country = c.Country
+			break
+		}
+	}
+
+	d.ModelYear = vinModelYear(vin, time.Now().Year())
+	return d
+}
+
+func inVINRange(vin, from, to string) bool {
+	if vin[0] != from[0] {
+		return false
+	}
+	if len(from) == 1 {
+		return true
+	}
+	pos := strings.IndexByte(vinRangeOrder, vin[1])
+	lo := strings.IndexByte(vinRangeOrder, from[1])
+	hi := strings.IndexByte(vinRangeOrder, to[1])
+	return pos >= 0 && lo >= 0 && hi >= 0 && pos >= lo && pos <= hi
+}
+
+// Модельный год по 10-й позиции. Код года обязателен только для Северной Америки
+// (WMI на 1–5) и Китая (L); у европейских VIN, в том числе грузовиков, позиция
+// свободна, и год по ней не определяется. Код повторяется раз в 30 лет: для
+// Северной Америки цикл определяет 7-я позиция, для Китая берётся ближайший год
+// не позже следующего календарного.
+func vinModelYear(vin string, currentYear int) int {
+	northAmerican := vin[0] >= '1' && vin[0] <= '5'
+	if !northAmerican && vin[0] != 'L' {
+		return 0
+	}
+	idx := strings.IndexByte(vinYearCodes, vin[9])
+	if idx < 0 {
+		return 0
+	}
+	year := 1980 + idx
+	if northAmerican {
+		if vin[6] < '0' || vin[6] > '9' {
+			year += 30
+		}
+		return year
+	}
+	for year+30 <= currentYear+1 {
+		year += 30
+	}
+	return year
+}
+
+// Марка из файла соответствует производителю по VIN: совпадение с маркой
+// или псевдонимом целиком либо вхождение в свободный текст (предмет лизинга v1)
+func (d VINDecode) brandMatches(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || d.Brand == "" {
+		return true
+	}
+	names := []string{d.Brand}
+	for _, m := range vinDecoder.Manufacturers {
+		if m.Brand == d.Brand {
+			names = append(names, m.Aliases...)
+		}
+	}
+	for _, name := range names {
+		if name != "" && strings.Contains(value, strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Расшифровка VIN записи и флаги расхождений с маркой и годом лизингодателя
+func decodeRecordVIN(def *SourceDefinition, rec *Record) {
+	rec.Decoded = vinDecoder.decode(rec.VIN())
+	rec.BrandMismatch = def.BrandField != "" && !rec.Decoded.brandMatches(rec.Values[def.BrandField])
+	rec.YearMismatch = false
+	if def.YearField != "" && rec.Decoded.ModelYear != 0 {
+		if year, err := strconv.Atoi(rec.Values[def.YearField]); err == nil {
+			rec.YearMismatch = year != rec.Decoded.ModelYear
+		}
+	}
+}
+
+// Пересчёт расшифровки для всех записей источника, например после обновления таблицы
+func redecodeStoredVINs(def *SourceDefinition) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s", selectColumns(def), def.Table))
+	if err != nil {
+		return err
+	}
+	var stale []Record
+	for rows.Next() {
+		rec, err := scanRecord(def, rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		before := rec
+		decodeRecordVIN(def, &rec)
+		if rec.Decoded != before.Decoded || rec.BrandMismatch != before.BrandMismatch || rec.YearMismatch != before.YearMismatch {
+			stale = append(stale, rec)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, rec := range stale {
+		if err := saveVINDecode(db, def, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GET /api/vin/{vin}: расшифровка произвольного VIN без обращения к базе
+func vinDecodeHandler(w http.ResponseWriter, r *http.Request) {
+	raw := mux.Vars(r)["vin"]
+	vin, err := normalizeVIN(raw)
+	response := map[string]interface{}{
+		"vin":     vin,
+		"valid":   err == nil,
+		"decoded": vinDecoder.decode(vin),
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestNormalizeVIN(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{"1HGCM82633A004352", "1HGCM82633A004352", false},
+		{" 1hgcm8 2633a004352 ", "1HGCM82633A004352", false},
+		// Кириллические С и М вместо латинских
+		{"1HGСМ82633A004352", "1HGCM82633A004352", false},
+		{"WDB9634031L123456", "WDB9634031L123456", false},
+		{"1HGCM82634A004352", "", true},
+		{"WDB9634031L12345", "", true},
+		{"WDB9634031L12345O", "", true},
+		{"WDB9634031L12345#", "", true},
+		{"XXXXXXXXXXXXXXXXX", "", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeVIN(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("normalizeVIN(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("normalizeVIN(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
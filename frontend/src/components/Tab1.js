@@ -7,6 +7,8 @@ import {
     clearChangedColumns,
     deleteAllRecords,
     getCellClass,
+    reviewRecords,
+    unreviewedVins,
     exportExcel
 } from '../utils/api';
 
@@ -91,11 +93,32 @@ function Tab1() {
         }
     };
 
+    // Отметка изменений просмотренными только для себя; остальные пользователи их по-прежнему видят
+    const handleReviewChanges = async () => {
+        const vins = unreviewedVins(records);
+        if (vins.length === 0) {
+            alert("Непросмотренных изменений нет");
+            return;
+        }
+
+        try {
+            const data = await reviewRecords('/api', vins);
+            if (!data) return;
+            setSuccessMessage(`Отмечено просмотренными: ${data.reviewed}`);
+            loadData();
+        } catch (err) {
+            alert("Ошибка: " + (err.response?.data || err.message));
+        }
+    };
+
     const handleClearChangedColumns = async () => {
         if (!window.confirm("Вы уверены, что хотите очистить все значения в колонке ChangedColumns?")) return;
 
+        const adminToken = window.prompt("Очистка для всех пользователей доступна администратору. Введите токен администратора:");
+        if (!adminToken) return;
+
         try {
-            const data = await clearChangedColumns();
+            const data = await clearChangedColumns(adminToken);
             alert(data.message || "ChangedColumns очищены!");
             loadData();
         } catch (err) {
@@ -180,7 +203,7 @@ function Tab1() {
             <div style={{ margin: '20px', padding: '10px', border: '1px solid red' }}>
                 <h3>Админские действия</h3>
                 <button onClick={handleClearChangedColumns} style={{ marginRight: '10px', padding: '10px', background: '#ff9800' }}>
-                    Очистить ChangedColumns
+                    Очистить ChangedColumns у всех
                 </button>
                 <button onClick={handleDeleteDatabase} style={{ marginRight: '10px', padding: '10px', background: '#f44336', color: 'white' }}>
                     Удалить всю базу
@@ -193,6 +216,10 @@ function Tab1() {
             <Segment>
                 <Header as="h3">
                     Таблица данных
+                    <Button floated="right" size="small" icon labelPosition="left" onClick={handleReviewChanges}>
+                        <Icon name="check" />
+                        Отметить просмотренными
+                    </Button>
                     <Button floated="right" size="small" icon labelPosition="left" onClick={loadData} loading={loading}>
                         <Icon name="refresh" />
                         Обновить
@@ -5,15 +5,61 @@ export const API_URL = process.env.REACT_APP_API_URL || 'http://localhost:8080';
 // Общие вспомогательные функции для работы с таблицами
 export const isColumnChanged = (record, column) => record.changed_columns?.includes(column);
 
+// Просмотренные текущим пользователем изменения не подсвечиваются
 export const getCellClass = (record, column) => {
+    if (record.reviewed) return '';
     if (record.is_new) return 'new-row';
     if (isColumnChanged(record, column)) return 'changed-cell';
     return '';
 };
 
-// API функции для Tab1
+// Имя пользователя для отметок о просмотре (заголовок X-User); хранится в браузере
+const USER_KEY = 'leasingUser';
+
+export const getCurrentUser = () => localStorage.getItem(USER_KEY) || '';
+
+export const askCurrentUser = () => {
+    const current = getCurrentUser();
+    if (current) return current;
+    const user = (window.prompt("Введите ваше имя для отметок о просмотре:") || '').trim();
+    if (user) localStorage.setItem(USER_KEY, user);
+    return user;
+};
+
+const userHeaders = () => {
+    const user = getCurrentUser();
+    return user ? { 'X-User': user } : {};
+};
+
+// Отметка записей просмотренными от имени текущего пользователя
+export const reviewRecords = async (prefix, vins) => {
+    const user = askCurrentUser();
+    if (!user) return null;
+    const res = await axios.post(`${API_URL}${prefix}/reviews`, { vins }, {
+        headers: { 'X-User': user },
+    });
+    return res.data;
+};
+
+// VIN новых и изменённых записей, ещё не просмотренных пользователем
+export const unreviewedVins = (records) =>
+    records
+        .filter(r => !r.reviewed && (r.is_new || r.changed_columns?.length > 0))
+        .map(r => r.vin);
+
+// Имена файлов из постраничного списка загрузок
+const fileNames = (data) => {
+    const items = Array.isArray(data?.items) ? data.items : [];
+    return [...new Set(items.map(u => u.file_name))];
+};
+
+// API функции для Tab1: список только новых и изменённых, без просмотренных пользователем
 export const fetchRecords = async () => {
-    const res = await axios.get(`${API_URL}/api/records`);
+    const headers = userHeaders();
+    const res = await axios.get(`${API_URL}/api/records`, {
+        headers,
+        params: headers['X-User'] ? { unreviewed: true } : {},
+    });
     return res.data || [];
 };
 
@@ -21,7 +67,7 @@ export const fetchFiles = async () => {
     const res = await fetch(`${API_URL}/api/files`);
     if (!res.ok) return [];
     const data = await res.json();
-    return Array.isArray(data) ? data : [];
+    return fileNames(data);
 };
 
 export const uploadFile = async (file) => {
@@ -35,11 +81,12 @@ export const uploadFile = async (file) => {
     return res.data || [];
 };
 
-export const clearChangedColumns = async () => {
+export const clearChangedColumns = async (adminToken) => {
     const response = await fetch(`${API_URL}/api/clear-changed-columns`, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        headers: { 'Content-Type': 'application/json', 'X-Admin-Token': adminToken || '' },
     });
+    if (!response.ok) throw new Error(await response.text());
     return await response.json();
 };
 
@@ -60,11 +107,11 @@ export const deleteAllRecords = async () => {
 
 // API функции для Tab2
 export const fetchRecordsV2 = async () => {
-    const res = await axios.get(`${API_URL}/api/v2/records`);
+    const res = await axios.get(`${API_URL}/api/v2/records`, { headers: userHeaders() });
     return res.data || [];
 };
 export const fetchRecordsV3 = async () => {
-    const res = await axios.get(`${API_URL}/api/v3/records`);
+    const res = await axios.get(`${API_URL}/api/v3/records`, { headers: userHeaders() });
     return res.data || [];
 };
 
@@ -72,13 +119,13 @@ export const fetchFilesV2 = async () => {
     const res = await fetch(`${API_URL}/api/v2/files`);
     if (!res.ok) return [];
     const data = await res.json();
-    return Array.isArray(data) ? data : [];
+    return fileNames(data);
 };
 export const fetchFilesV3 = async () => {
     const res = await fetch(`${API_URL}/api/v3/files`);
     if (!res.ok) return [];
     const data = await res.json();
-    return Array.isArray(data) ? data : [];
+    return fileNames(data);
 };
 
 export const uploadFileV2 = async (file) => {
@@ -102,18 +149,20 @@ export const uploadFileV3 = async (file) => {
     return res.data.records || [];
 };
 
-export const clearChangedColumnsV2 = async () => {
+export const clearChangedColumnsV2 = async (adminToken) => {
     const response = await fetch(`${API_URL}/api/v2/clear-changed-columns`, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        headers: { 'Content-Type': 'application/json', 'X-Admin-Token': adminToken || '' },
     });
+    if (!response.ok) throw new Error(await response.text());
     return await response.json();
 };
-export const clearChangedColumnsV3 = async () => {
+export const clearChangedColumnsV3 = async (adminToken) => {
     const response = await fetch(`${API_URL}/api/v3/clear-changed-columns`, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        headers: { 'Content-Type': 'application/json', 'X-Admin-Token': adminToken || '' },
     });
+    if (!response.ok) throw new Error(await response.text());
     return await response.json();
 };
 